@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// groupAdminsTTL is how long list of group admins is cached, so telegram is not asked about it on every command.
+const groupAdminsTTL = 5 * time.Minute
+
+var (
+	errNotAllowed    = errors.New("user and chat are not on allowlist")
+	errNotGroupAdmin = errors.New("command can be run only by group admins")
+)
+
+// accessConfig limits who can use bot. Users and Chats are allowlists of telegram user and chat IDs, bot can be used by listed users or in listed chats. If both are empty, everyone can use bot.
+type accessConfig struct {
+	Users []int64 `yaml:"users"`
+	Chats []int64 `yaml:"chats"`
+}
+
+// authorizers is authorizer which lets run command only if all its authorizers allow it.
+type authorizers []authorizer
+
+func (as authorizers) Authorize(u Update, c *command) error {
+	for _, a := range as {
+		if err := a.Authorize(u, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allowlistAuthorizer lets use bot only to users and in chats from allowlist.
+type allowlistAuthorizer struct {
+	users map[int64]bool
+	chats map[chatid]bool
+}
+
+// newAllowlistAuthorizer creates authorizer from access config. Bot admins are always allowed, unless allowlist is empty and everyone is allowed anyway.
+func newAllowlistAuthorizer(ac accessConfig, admins []int64) allowlistAuthorizer {
+	aa := allowlistAuthorizer{users: make(map[int64]bool), chats: make(map[chatid]bool)}
+	for _, id := range ac.Users {
+		aa.users[id] = true
+	}
+	for _, id := range ac.Chats {
+		aa.chats[chatid(id)] = true
+	}
+	if len(aa.users) > 0 || len(aa.chats) > 0 {
+		for _, id := range admins {
+			aa.users[id] = true
+		}
+	}
+	return aa
+}
+
+func (aa allowlistAuthorizer) Authorize(u Update, _ *command) error {
+	if len(aa.users) == 0 && len(aa.chats) == 0 {
+		return nil
+	}
+	if aa.users[u.UserID] || aa.chats[u.ChatID] {
+		return nil
+	}
+	return errNotAllowed
+}
+
+// cachedAdmins is a list of group admins with time when it was fetched.
+type cachedAdmins struct {
+	ids     map[int64]bool
+	fetched time.Time
+}
+
+// groupAdminAuthorizer lets only group admins run destructive commands in groups, so one member can't delete data of whole group. In private chats user can run them, because data is only his.
+// Admins returns IDs of group admins, lists are cached for ttl.
+type groupAdminAuthorizer struct {
+	admins func(chatid) ([]int64, error)
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[chatid]cachedAdmins
+}
+
+// newGroupAdminAuthorizer creates authorizer which asks given function for group admins.
+func newGroupAdminAuthorizer(admins func(chatid) ([]int64, error), ttl time.Duration) *groupAdminAuthorizer {
+	return &groupAdminAuthorizer{admins: admins, ttl: ttl, cache: make(map[chatid]cachedAdmins)}
+}
+
+// Authorize checks if user is admin of group in which he runs destructive command. If admins can't be fetched, command is not allowed.
+func (ga *groupAdminAuthorizer) Authorize(u Update, c *command) error {
+	// group chats have negative IDs
+	if !c.Destructive || u.ChatID >= 0 {
+		return nil
+	}
+
+	ids, err := ga.groupAdmins(u.ChatID)
+	if err != nil {
+		generateDialogLogger(u.ChatID).Error("Could not get group admins: " + err.Error())
+		return err
+	}
+	if !ids[u.UserID] {
+		return errNotGroupAdmin
+	}
+	return nil
+}
+
+// groupAdmins returns cached admins of group, or fetches them if cache is empty or too old.
+func (ga *groupAdminAuthorizer) groupAdmins(chatID chatid) (map[int64]bool, error) {
+	ga.mu.Lock()
+	cached, ok := ga.cache[chatID]
+	ga.mu.Unlock()
+	if ok && time.Since(cached.fetched) < ga.ttl {
+		return cached.ids, nil
+	}
+
+	admins, err := ga.admins(chatID)
+	if err != nil {
+		return nil, err
+	}
+	cached = cachedAdmins{ids: make(map[int64]bool), fetched: time.Now()}
+	for _, id := range admins {
+		cached.ids[id] = true
+	}
+
+	ga.mu.Lock()
+	ga.cache[chatID] = cached
+	ga.mu.Unlock()
+	log.WithField("chat", chatID).Debug("Fetched group admins")
+	return cached.ids, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errNotAdmin = errors.New("command is only for admins")
+
+// adminAuthorizer lets only admins run admin commands, other commands can be run by everyone.
+type adminAuthorizer struct {
+	admins map[int64]bool
+}
+
+// newAdminAuthorizer creates authorizer with given admin user IDs.
+func newAdminAuthorizer(admins []int64) adminAuthorizer {
+	aa := adminAuthorizer{admins: make(map[int64]bool)}
+	for _, id := range admins {
+		aa.admins[id] = true
+	}
+	return aa
+}
+
+func (aa adminAuthorizer) Authorize(u Update, c *command) error {
+	if c.Admin && !aa.admins[u.UserID] {
+		return errNotAdmin
+	}
+	return nil
+}
+
+// Admin runs admin subcommand given in payload: stats, broadcast {text}, reload or dump {chatid}.
+func (b *Bot) Admin(u Update) {
+	sub, arg := u.Payload(), ""
+	if i := strings.IndexAny(sub, " \n"); i >= 0 {
+		sub, arg = sub[:i], strings.TrimSpace(sub[i+1:])
+	}
+
+	adminLogger := log.WithFields(log.Fields{
+		"user":       u.UserID,
+		"subcommand": sub,
+	})
+	adminLogger.Info("Admin command")
+
+	switch strings.ToLower(sub) {
+	case "stats":
+		b.AdminStats(u.ChatID)
+		return
+	case "broadcast":
+		if arg != "" {
+			b.Broadcast(u.ChatID, arg)
+			return
+		}
+	case "reload":
+		b.Reload(u.ChatID)
+		return
+	case "dump":
+		if chatID, err := strconv.ParseInt(arg, 10, 64); err == nil {
+			b.DumpChat(u, chatid(chatID))
+			return
+		}
+	}
+	b.Output <- Msg{chatID: u.ChatID, text: b.T(u.ChatID, "admin.usage")}
+}
+
+// AdminStats sends number of chats and their data.
+func (b *Bot) AdminStats(chatID chatid) {
+	st, err := b.Store.Stats()
+	if err != nil {
+		b.Output <- Msg{chatID: chatID, text: b.T(chatID, "error.tryLater")}
+		return
+	}
+	b.Output <- Msg{chatID: chatID, text: b.T(chatID, "admin.stats", st.Chats, st.Topics, st.Flashcards, st.Reminders, st.Classes, st.PendingMessages)}
+}
+
+// Broadcast sends text to every chat which has any data.
+func (b *Bot) Broadcast(chatID chatid, text string) {
+	chats, err := b.Store.Chats()
+	if err != nil {
+		b.Output <- Msg{chatID: chatID, text: b.T(chatID, "error.tryLater")}
+		return
+	}
+	for _, c := range chats {
+		b.Output <- Msg{chatID: c, text: text}
+	}
+	b.Output <- Msg{chatID: chatID, text: b.T(chatID, "admin.broadcastSent", len(chats))}
+}
+
+// Reload reads data again from store's source and sets reminders which were added outside of bot.
+func (b *Bot) Reload(chatID chatid) {
+	if err := b.Store.Reload(); err != nil {
+		log.Error("Could not reload data: " + err.Error())
+		b.Output <- Msg{chatID: chatID, text: b.T(chatID, "admin.reloadFailed", err.Error())}
+		return
+	}
+	b.SetReminders()
+	b.Output <- Msg{chatID: chatID, text: b.T(chatID, "admin.reloaded")}
+}
+
+// DumpChat sends all data of given chat as json file. It works only in admin's private chat, in group every member would get data of other chat.
+func (b *Bot) DumpChat(u Update, dumped chatid) {
+	// private chat has the same ID as its user
+	if u.ChatID != chatid(u.UserID) {
+		b.Output <- Msg{chatID: u.ChatID, text: b.T(u.ChatID, "admin.dumpPrivateOnly")}
+		return
+	}
+	b.sendChatData(u.ChatID, dumped, b.T(u.ChatID, "admin.dumpCaption", dumped))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// backupArchiveFile returns name of file of given kind inside backup archive.
+func backupArchiveFile(kind string) string {
+	return kind + ".json"
+}
+
+// collectData reads data of every chat from store into json store kept in memory, so it can be written in format of json data files.
+func collectData(s Store) (*jsonStore, error) {
+	js := newMemoryJSONStore()
+	chats, err := s.Chats()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chatID := range chats {
+		topics, err := s.Flashcards(chatID)
+		if err != nil {
+			return nil, err
+		}
+		if len(topics) > 0 {
+			js.flashcards[chatID] = topics
+		}
+		reminders, err := s.Reminders(chatID)
+		if err != nil {
+			return nil, err
+		}
+		if len(reminders) > 0 {
+			js.reminders[chatID] = reminders
+		}
+		sd, err := s.Schedule(chatID)
+		if err != nil {
+			return nil, err
+		}
+		if len(sd) > 0 {
+			js.schedules[chatID] = sd
+		}
+		lang, err := s.Language(chatID)
+		if err != nil {
+			return nil, err
+		}
+		if lang != "" {
+			js.languages[chatID] = lang
+		}
+	}
+
+	if js.outbox, err = s.PendingMessages(); err != nil {
+		return nil, err
+	}
+	return js, nil
+}
+
+// exportBackup writes all data of store into archive. Archive is tar.gz with json data file of every kind, in the same format as files of json storage, so it can be imported into any backend or unpacked and used as data files. Archive is encrypted with primary key of keys.
+func exportBackup(s Store, fileName string, keys *keyring) error {
+	js, err := collectData(s)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		kind string
+		data interface{}
+	}{
+		{flashcardsKind, js.flashcards},
+		{remindersKind, js.reminders},
+		{schedulesKind, js.schedules},
+		{outboxKind, js.outbox},
+		{languagesKind, js.languages},
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range files {
+		data, err := encodeVersionedData(f.kind, f.data)
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:    backupArchiveFile(f.kind),
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: now,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+
+	archive, err := keys.seal(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if err = writeDataFile(fileName, archive, 0); err != nil {
+		return err
+	}
+
+	st, _ := js.Stats()
+	log.WithFields(log.Fields{
+		"file":       fileName,
+		"chats":      st.Chats,
+		"flashcards": st.Flashcards,
+		"reminders":  st.Reminders,
+		"classes":    st.Classes,
+	}).Info("Exported data")
+	return nil
+}
+
+// readBackup reads data from backup archive written by exportBackup. Data written by older version of bot is migrated to current format.
+func readBackup(fileName string, keys *keyring) (*jsonStore, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = keys.open(content); err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.New(fileName + " is not a backup archive")
+	}
+
+	js := newMemoryJSONStore()
+	files := map[string]struct {
+		kind string
+		data interface{}
+	}{
+		backupArchiveFile(flashcardsKind): {flashcardsKind, &js.flashcards},
+		backupArchiveFile(remindersKind):  {remindersKind, &js.reminders},
+		backupArchiveFile(schedulesKind):  {schedulesKind, &js.schedules},
+		backupArchiveFile(outboxKind):     {outboxKind, &js.outbox},
+		backupArchiveFile(languagesKind):  {languagesKind, &js.languages},
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New(fileName + " is broken: " + err.Error())
+		}
+
+		f, ok := files[hdr.Name]
+		if !ok {
+			return nil, errors.New(fileName + " has unknown file " + hdr.Name)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, errors.New(fileName + " is broken: " + err.Error())
+		}
+		// files inside archive are never encrypted, the whole archive is
+		if _, err = decodeJSONFile(data, f.kind, nil, f.data); err != nil {
+			return nil, errors.New(hdr.Name + " in " + fileName + ": " + err.Error())
+		}
+		delete(files, hdr.Name)
+	}
+	if len(files) > 0 {
+		missing := []string{}
+		for name := range files {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, errors.New(fileName + " doesn't have " + strings.Join(missing, ", "))
+	}
+
+	js.ensureMaps()
+	return js, nil
+}
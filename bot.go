@@ -2,55 +2,73 @@ package main
 
 import (
 	//"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"io/ioutil"
 	"os"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	tba "gopkg.in/tucnak/telebot.v2" //telegram bot api
 )
 
-const funcs = `
-/version - podaje aktualną wersje
-/fiszka {nazwa} - podaje fiszke pod podaną nazwą
-/dodajfiszke - uruchamia dialog dodawania fiszki
-/usunfiszke - uruchamia dialog usuwania fiszki
-/edytujfiszke - uruchamia dialog edytowania fiszki
-/test -  uruchamia test wiedzy
-/dodajprzypomnienie - uruchamia dialog dodawania przypomnienia
-/pokazprzypomnienia - wypisuje listę aktualnych przypomnień
-/dodajzajecia - uruchamia dialog dodawania zajęć
-/edytujzajecia - uruchamia dialog edytowania zajęć
-/usunzajecia - uruchamia dialog usuwania zajęć
-/plan - wypisuje plan zajec
-/usunplan - uruchamia dialog usuwania zajęć
-`
+const version = "0.4.0"
+
+var (
+	errDialogEnded = errors.New("ended dialog")
+	errTimeout     = errors.New("timeout")
+)
 
 type chatid int64
 
-// Bot struct stores api, data and all necessary channels.
-// FlashcardsData stores all flashcards by chat ID.
-// RemindersData stores all reminders by chat ID.
-// SchedulesData stores all schedules by chat ID.
-// Input is a channel for managing all messages from chats.
-// InactiveInput is a channel that informs bot about exiting dialogs so he can make it available for other dialog.
+// Bot struct stores messenger, data and all necessary channels.
+// Quit is closed when bot shuts down, outputStop and outputDone are used to send waiting messages before exit, drainUntil is when sending them stops.
+// Store keeps flashcards, reminders and schedules of all chats.
+// Sessions keeps dialogs opened in chats and passes them user's messages.
 // Output is a channel for sending message to chats.
+// GradeScale is used for grading exam results.
+// DialogTimeout is how long bot waits for user's answer in dialog.
+// ReminderOffsets are times before reminder's date when it is sent, sorted from the longest one.
+// OutboundLimits limit how fast messages are sent.
+// Authorizer decides who can run commands.
+// Limiter decides how often commands can be run.
+// Limits limit number of flashcards and reminders in chat and length of answers.
+// scheduled keeps reminders which are waiting in Remind, so they are not set twice.
 type Bot struct {
-	api            *tba.Bot
-	FlashcardsData flashcardsData
-	RemindersData  remindersData
-	SchedulesData  schedulesData
-	Input          map[chatid]chan string
-	InactiveInput  chan chatid
-	Output         chan Msg
+	messenger       Messenger
+	router          *commandRouter
+	quit            chan struct{}
+	outputStop      chan struct{}
+	outputDone      chan struct{}
+	drainUntil      time.Time
+	Store           Store
+	Sessions        *sessionManager
+	Output          chan Msg
+	GradeScale      gradeScale
+	DialogTimeout   time.Duration
+	ReminderOffsets []time.Duration
+	OutboundLimits  outboundConfig
+	Authorizer      authorizer
+	Limiter         rateLimiter
+	Limits          limitsConfig
+
+	remindersMu sync.Mutex
+	scheduled   map[reminderKey]bool
 }
 
-// Msg is basic message struct. It stores desired chat ID and text message.
+// Msg is basic message struct. It stores desired chat ID and text message. Format tells if text is plain or HTML. If keyboard is not empty, its options are shown to user as buttons. PendingID is set for messages kept in store until they are delivered. If document is set, it is sent as file and text is its caption.
 type Msg struct {
-	chatID chatid
-	text   string
+	chatID    chatid
+	text      string
+	format    textFormat
+	keyboard  []string
+	pendingID string
+	document  *document
+}
+
+// document is a file sent to chat.
+type document struct {
+	fileName string
+	data     []byte
 }
 
 // generateDialogLogger creates logger for dialog errors
@@ -68,305 +86,342 @@ func generateIoLogger(filename string, funcname string) *log.Entry {
 	})
 }
 
-// defaultSendOpt stores default config for sending messages to chat.
-func defaultSendOpt() *tba.SendOptions {
-	return &tba.SendOptions{}
-}
-
-func ensureDataFileExists(fileName string) error {
-	if _, err := os.Stat(fileName); err != nil {
-		err = ioutil.WriteFile(fileName, []byte("{}"), 0644)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"file": fileName,
-			}).Fatal("could not create file")
-			return err
-		}
-	}
-	return nil
-
-}
-
-// HandleOutput listens for messages on Output channel and sends them to desired chat.
-func (b *Bot) HandleOutput() {
-	for m := range b.Output {
-		_ = b.SendMessage(m.chatID, m.text, defaultSendOpt())
+// getAnswer listens for users message in dialog's session and returns it. If user doesn't respond in given time it returns errTimeout. If dialog was cancelled, e.g. because user started a new one, it returns errDialogEnded.
+func getAnswer(ctx context.Context, timeout time.Duration) (string, error) {
+	s := sessionFromContext(ctx)
+	select {
+	case a := <-s.input:
+		return a, nil
+	case <-ctx.Done():
+		return "", errDialogEnded
+	case <-time.After(timeout):
+		return "", errTimeout
 	}
 }
 
-// InputKiller listens for chat IDs on InactiveInput channel and then deletes desired Input channel.
-func (b *Bot) InputKiller() {
-	for id := range b.InactiveInput {
-		close(b.Input[id])
-		delete(b.Input, id)
-	}
+// Dialog handles basic user-bot interaction. Bot will ask given question, and then listen for user's answer. If everything is correct it will return answer.
+func (b *Bot) Dialog(ctx context.Context, chatID chatid, question string) (string, error) {
+	return b.DialogWithOptions(ctx, chatID, question, nil)
 }
 
-// SendMessage creates messages specific for telegram api and then sends them to desired chat. It wraps chatid to chat object, because it is requirment for tucnak's package.
-func (b *Bot) SendMessage(chat chatid, message string, sendOpt *tba.SendOptions) error {
-	tmpChat := tba.Chat{ID: int64(chat), Title: "", FirstName: "", LastName: "", Type: "", Username: ""}
-	_, err := b.api.Send(&tmpChat, message, sendOpt)
+// DialogWithOptions works like Dialog, but also shows user a keyboard with suggested answers.
+func (b *Bot) DialogWithOptions(ctx context.Context, chatID chatid, question string, options []string) (string, error) {
+	b.Output <- Msg{chatID: chatID, text: question, keyboard: options}
+	a, err := getAnswer(ctx, b.DialogTimeout)
 
 	if err != nil {
+		if err == errDialogEnded {
+			return a, err
+		}
 		log.WithFields(log.Fields{
-			"chat":    chat,
-			"message": message,
-		}).Error("Could not send message")
+			"chat": chatID,
+		}).Info("User did not answer in given time")
+		dialogTimeouts.Inc()
+		b.Output <- Msg{chatID: chatID, text: b.T(chatID, "dialog.timeout")}
 	}
 
-	return err
+	return a, err
 }
 
-// getAnswer listens for users message and returns it. If user doesn't respond in given time it returns error. If input is empty string it will return error. It's good for ending opened dialog when we want to start a new one.
-func getAnswer(in chan string) (string, error) {
-	select {
-	case a := <-in:
-		if a == "" {
-			return a, errors.New("ended dialog")
-		}
-		return a, nil
-	case <-time.After(10 * time.Minute):
-		return "", errors.New("timeout")
+// HandleUpdate passes update to its command through all middleware. If update is not a known command and chat has opened dialog, it is passed as an answer.
+func (b *Bot) HandleUpdate(u Update) {
+	c, ok := b.router.Find(u.Command())
+	if !ok {
+		b.Sessions.Deliver(u.ChatID, u.Text)
+		return
 	}
-}
 
-// Dialog handles basic user-bot interaction. Bot will ask given question, and then listen for user's answer. If everything is correct it will return answer.
-func (b *Bot) Dialog(chatID chatid, question string) (string, error) {
-	b.Output <- Msg{chatID, question}
-	a, err := getAnswer(b.Input[chatID])
+	if !c.opensDialog(u) {
+		b.router.Wrap(c, c.Handler)(context.Background(), u)
+		return
+	}
 
-	if err != nil {
-		if err.Error() == "ended dialog" {
-			return a, err
-		}
-		log.WithFields(log.Fields{
-			"chat": chatID,
-		}).Info("User did not answer in given time")
-		b.Output <- Msg{chatID, "Przekroczono czas odpowiedzi"}
+	// dialog runs in its own goroutine, so panics have to be recovered there as well
+	start := func(_ context.Context, u Update) {
+		b.Sessions.Start(u.ChatID, func(ctx context.Context) {
+			b.recoveryMiddleware(c, c.Handler)(ctx, u)
+		})
 	}
+	b.router.Wrap(c, start)(context.Background(), u)
+}
 
-	return a, err
+// dialogHandler adapts dialog function to command handler.
+func dialogHandler(dialog func(context.Context, chatid)) commandHandler {
+	return func(ctx context.Context, u Update) {
+		dialog(ctx, u.ChatID)
+	}
 }
 
 // Help sends to user list of available commands
 func (b *Bot) Help(chatID chatid) {
-	b.Output <- Msg{chatID, funcs}
+	b.Output <- Msg{chatID: chatID, text: b.router.Help(b.Language(chatID))}
 }
 
-//Run starts all handlers and listeners for bot
-func (b *Bot) Run() {
-
-	go b.HandleOutput()
-	go b.InputKiller()
-	b.SetReminders()
-
-	b.api.Handle("/version", func(m *tba.Message) {
-		b.Output <- Msg{chatid(m.Chat.ID), "version 0.4.0"}
+// registerCommands registers all bot commands and middleware. Commands are shown in help in the same order. Rate limit goes before auth, so refused users can't make bot answer them without limit. Metrics go last, so only commands which really run are counted as handled.
+func (b *Bot) registerCommands() {
+	b.router.Use(b.recoveryMiddleware)
+	b.router.Use(loggingMiddleware)
+	b.router.Use(b.rateLimitMiddleware)
+	b.router.Use(b.authMiddleware)
+	b.router.Use(metricsMiddleware)
+
+	b.router.Register(&command{
+		Name:        "/help",
+		Description: "cmd.help",
+		Aliases:     []string{"/start", "/pomoc"},
+		Handler: func(_ context.Context, u Update) {
+			b.Help(u.ChatID)
+		},
 	})
-
-	b.api.Handle("/help", func(m *tba.Message) {
-		go b.Help(chatid(m.Chat.ID))
+	b.router.Register(&command{
+		Name:        "/version",
+		Description: "cmd.version",
+		Handler: func(_ context.Context, u Update) {
+			b.Output <- Msg{chatID: u.ChatID, text: b.T(u.ChatID, "version", version)}
+		},
 	})
-
-	//single line commands don't stop routines
-	b.api.Handle("/fiszka", func(m *tba.Message) {
-		b.DisplayFlashcard(m)
+	b.router.Register(&command{
+		Name:        "/fiszka",
+		Args:        "args.flashcard",
+		Description: "cmd.flashcard",
+		Handler: func(_ context.Context, u Update) {
+			b.DisplayFlashcard(u.ChatID, u.Payload())
+		},
 	})
-
-	b.api.Handle("/dodajfiszke", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-		if _, ok := b.Input[chatID]; ok {
-			b.Input[chatID] <- ""
-			//TODO: make it without sleep
-			time.Sleep(2 * time.Second)
-		}
-		b.Input[chatID] = make(chan string)
-		go b.AddFlashcard(chatID)
+	b.router.Register(&command{
+		Name:        "/dodajfiszke",
+		Description: "cmd.addFlashcard",
+		Dialog:      true,
+		Handler:     dialogHandler(b.AddFlashcard),
 	})
-
-	b.api.Handle("/usunfiszke", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-		if _, ok := b.Input[chatID]; ok {
-			b.Input[chatID] <- ""
-			time.Sleep(2 * time.Second)
-		}
-		b.Input[chatID] = make(chan string)
-		go b.DeleteFlashcard(chatID)
+	b.router.Register(&command{
+		Name:        "/usunfiszke",
+		Description: "cmd.deleteFlashcard",
+		Dialog:      true,
+		Destructive: true,
+		Handler:     dialogHandler(b.DeleteFlashcard),
 	})
-
-	b.api.Handle("/edytujfiszke", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-		if _, ok := b.Input[chatID]; ok {
-			b.Input[chatID] <- ""
-			time.Sleep(2 * time.Second)
-		}
-		b.Input[chatID] = make(chan string)
-		go b.EditFlashcard(chatID)
+	b.router.Register(&command{
+		Name:        "/edytujfiszke",
+		Description: "cmd.editFlashcard",
+		Dialog:      true,
+		Handler:     dialogHandler(b.EditFlashcard),
 	})
-
-	b.api.Handle("/test", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-		if _, ok := b.Input[chatID]; ok {
-			b.Input[chatID] <- ""
-			time.Sleep(2 * time.Second)
-		}
-		b.Input[chatID] = make(chan string)
-		go b.KnowledgeTest(chatID)
+	b.router.Register(&command{
+		Name:        "/usuntemat",
+		Description: "cmd.deleteTopic",
+		Dialog:      true,
+		Destructive: true,
+		Handler:     dialogHandler(b.DeleteTopic),
 	})
-
-	b.api.Handle("/dodajprzypomnienie", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-		if _, ok := b.Input[chatID]; ok {
-			b.Input[chatID] <- ""
-			time.Sleep(2 * time.Second)
-		}
-		b.Input[chatID] = make(chan string)
-		go b.AddReminder(chatID)
+	b.router.Register(&command{
+		Name:        "/test",
+		Description: "cmd.test",
+		Dialog:      true,
+		Handler:     dialogHandler(b.KnowledgeTest),
 	})
-
-	b.api.Handle("/pokazprzypomnienia", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-
-		go b.ShowReminders(chatID)
+	b.router.Register(&command{
+		Name:        "/egzamin",
+		Args:        "args.exam",
+		Description: "cmd.exam",
+		Dialog:      true,
+		Handler: func(ctx context.Context, u Update) {
+			b.Exam(ctx, u.ChatID, u.Payload())
+		},
 	})
-
-	b.api.Handle("/dodajzajecia", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-		if _, ok := b.Input[chatID]; ok {
-			b.Input[chatID] <- ""
-			time.Sleep(2 * time.Second)
-		}
-		b.Input[chatID] = make(chan string)
-		go b.AddClass(chatID)
+	b.router.Register(&command{
+		Name:        "/dodajprzypomnienie",
+		Description: "cmd.addReminder",
+		Dialog:      true,
+		Handler:     dialogHandler(b.AddReminder),
 	})
-
-	b.api.Handle("/edytujzajecia", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-		if _, ok := b.Input[chatID]; ok {
-			b.Input[chatID] <- ""
-			time.Sleep(2 * time.Second)
-		}
-		b.Input[chatID] = make(chan string)
-		go b.EditClass(chatID)
+	b.router.Register(&command{
+		Name:        "/pokazprzypomnienia",
+		Description: "cmd.showReminders",
+		Handler: func(_ context.Context, u Update) {
+			b.ShowReminders(u.ChatID)
+		},
 	})
-
-	b.api.Handle("/usunzajecia", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-		if _, ok := b.Input[chatID]; ok {
-			b.Input[chatID] <- ""
-			time.Sleep(2 * time.Second)
-		}
-		b.Input[chatID] = make(chan string)
-		go b.DeleteClass(chatID)
+	b.router.Register(&command{
+		Name:        "/dodajzajecia",
+		Description: "cmd.addClass",
+		Dialog:      true,
+		Handler:     dialogHandler(b.AddClass),
 	})
-
-	b.api.Handle("/usunplan", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-		if _, ok := b.Input[chatID]; ok {
-			b.Input[chatID] <- ""
-			time.Sleep(2 * time.Second)
-		}
-		b.Input[chatID] = make(chan string)
-		go b.DeleteSchedule(chatID)
+	b.router.Register(&command{
+		Name:        "/edytujzajecia",
+		Description: "cmd.editClass",
+		Dialog:      true,
+		Handler:     dialogHandler(b.EditClass),
 	})
-
-	b.api.Handle("/plan", func(m *tba.Message) {
-		chatID := chatid(m.Chat.ID)
-
-		go b.ShowSchedule(chatID)
+	b.router.Register(&command{
+		Name:        "/usunzajecia",
+		Description: "cmd.deleteClass",
+		Dialog:      true,
+		Destructive: true,
+		Handler:     dialogHandler(b.DeleteClass),
 	})
-
-	b.api.Handle(tba.OnText, func(m *tba.Message) {
-		if d, ok := b.Input[chatid(m.Chat.ID)]; ok {
-			d <- m.Text
-		}
+	b.router.Register(&command{
+		Name:        "/plan",
+		Description: "cmd.schedule",
+		Handler: func(_ context.Context, u Update) {
+			b.ShowSchedule(u.ChatID)
+		},
+	})
+	b.router.Register(&command{
+		Name:        "/usunplan",
+		Description: "cmd.deleteSchedule",
+		Dialog:      true,
+		Destructive: true,
+		Handler:     dialogHandler(b.DeleteSchedule),
+	})
+	b.router.Register(&command{
+		Name:        "/jezyk",
+		Args:        "args.language",
+		Description: "cmd.language",
+		Aliases:     []string{"/language"},
+		Dialog:      true,
+		// language given in payload is set at once, so dialog opened before goes on
+		Inline: func(u Update) bool {
+			return u.Payload() != ""
+		},
+		Handler: func(ctx context.Context, u Update) {
+			b.SetLanguage(ctx, u.ChatID, u.Payload())
+		},
+	})
+	b.router.Register(&command{
+		Name:        "/moje_dane",
+		Description: "cmd.exportData",
+		Aliases:     []string{"/my_data"},
+		Handler: func(_ context.Context, u Update) {
+			b.ExportData(u.ChatID)
+		},
+	})
+	b.router.Register(&command{
+		Name:        "/usun_moje_dane",
+		Description: "cmd.deleteData",
+		Aliases:     []string{"/delete_my_data"},
+		Dialog:      true,
+		Destructive: true,
+		Handler:     dialogHandler(b.DeleteData),
+	})
+	b.router.Register(&command{
+		Name:        "/admin",
+		Args:        "args.admin",
+		Description: "cmd.admin",
+		Admin:       true,
+		Handler: func(_ context.Context, u Update) {
+			b.Admin(u)
+		},
+	})
+	b.router.Register(&command{
+		Name:        backCommand,
+		Description: "cmd.back",
+		Handler: func(_ context.Context, u Update) {
+			b.Back(u.ChatID)
+		},
+	})
+	b.router.Register(&command{
+		Name:        cancelCommand,
+		Description: "cmd.cancel",
+		Aliases:     []string{"/cancel"},
+		Handler: func(_ context.Context, u Update) {
+			b.Cancel(u.ChatID)
+		},
 	})
-
-	b.api.Start()
-
 }
 
-//NewBot creates new bot instance under given telegram api token. If env is prod it will log all errors and info to a file.
-func NewBot(token string, env string) *Bot {
-
-	if env == "prod" {
-		log.SetFormatter(&log.JSONFormatter{})
-		file, err := os.OpenFile("logrus.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		if err != nil {
-			log.Info("Failed to log to file, using stderr")
-		} else {
-			log.SetOutput(file)
-		}
-	}
+// Run starts all handlers and listeners for bot. It returns when messenger stops, then Shutdown should be called.
+func (b *Bot) Run() {
 
-	tb, err := tba.NewBot(tba.Settings{
-		Token:  token,
-		Poller: &tba.LongPoller{Timeout: 10 * time.Second},
-	})
+	go b.HandleOutput()
+	b.ResendPending()
+	b.SetReminders()
 
-	if err != nil {
-		log.Fatal("Could not create bot")
+	b.registerCommands()
+	if err := b.messenger.SetCommands(b.router.Infos(defaultLanguage)); err != nil {
+		log.Error("Could not set commands list: " + err.Error())
 	}
 
-	flashcards := make(flashcardsData)
-	_ = ensureDataFileExists(flashcardsFileName)
-	flashcardsData, err := ioutil.ReadFile(flashcardsFileName)
-
-	if err != nil {
-		log.WithFields(log.Fields{
-			"file": flashcardsFileName,
-		}).Fatal("Could not read file")
-	}
+	b.messenger.Start(b.HandleUpdate)
 
-	err = json.Unmarshal([]byte(flashcardsData), &flashcards)
+}
 
-	if err != nil {
-		log.WithFields(log.Fields{
-			"file": flashcardsFileName,
-		}).Fatal("Could not decode file")
-	}
+// Stop stops receiving updates, so Run returns.
+func (b *Bot) Stop() {
+	b.messenger.Stop()
+}
 
-	reminders := make(remindersData)
-	_ = ensureDataFileExists(remindersFileName)
-	remindersData, err := ioutil.ReadFile(remindersFileName)
+// Shutdown stops reminders, cancels opened dialogs and tells users about it, sends waiting messages and closes store. Messages which are not sent before timeout are lost, unless they are kept in store. Store is closed even after timeout, so its data is saved.
+func (b *Bot) Shutdown(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		close(b.quit)
 
-	if err != nil {
-		log.WithFields(log.Fields{
-			"file": remindersFileName,
-		}).Fatal("Could not read file")
-	}
+		for _, chatID := range b.Sessions.CancelAll() {
+			b.Output <- Msg{chatID: chatID, text: b.T(chatID, "dialog.shutdown")}
+		}
 
-	err = json.Unmarshal([]byte(remindersData), &reminders)
+		// drainUntil is set before outputStop is closed, so HandleOutput sees it after stop
+		b.drainUntil = deadline
+		close(b.outputStop)
+		<-b.outputDone
+	}()
 
-	if err != nil {
-		log.WithFields(log.Fields{
-			"file": remindersFileName,
-		}).Fatal("Could not decode file")
+	select {
+	case <-done:
+	case <-time.After(time.Until(deadline)):
+		log.Error("Bot did not send all messages in " + timeout.String() + ", some of them are lost")
 	}
 
-	schedules := make(schedulesData)
-	_ = ensureDataFileExists(schedulesFileName)
-	schedulesData, err := ioutil.ReadFile(schedulesFileName)
-
-	if err != nil {
-		log.WithFields(log.Fields{
-			"file": schedulesFileName,
-		}).Fatal("Could not read file")
+	if err := b.Store.Close(); err != nil {
+		log.Error("Could not close store: " + err.Error())
+		return
 	}
+	log.Info("Bot stopped")
+}
 
-	err = json.Unmarshal([]byte(schedulesData), &schedules)
-
-	if err != nil {
-		log.WithFields(log.Fields{
-			"file": schedulesFileName,
-		}).Fatal("Could not decode file")
+// setupLogging configures logger for given env. If env is prod it will log all errors and info as json to given file.
+func setupLogging(env string, logFile string) {
+	if env == "prod" {
+		log.SetFormatter(&log.JSONFormatter{})
+		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			log.Info("Failed to log to file, using stderr")
+		} else {
+			log.SetOutput(file)
+		}
 	}
+}
 
-	input := make(map[chatid]chan string)
-	inactiveInput := make(chan chatid)
-	output := make(chan Msg)
-
+// NewBot creates new bot instance which talks with users through given messenger, keeps data in given store and uses given settings.
+func NewBot(messenger Messenger, store Store, cfg *config) *Bot {
 	log.Info("Bot authorized")
-	return &Bot{tb, flashcards, reminders, schedules, input, inactiveInput, output}
+	// allowlist is checked first, so strangers are refused before telegram is asked for group admins. Refusal names no command, but it still shows that command exists, unknown commands get no answer
+	auth := authorizers{
+		newAllowlistAuthorizer(cfg.Access, cfg.Admins),
+		newAdminAuthorizer(cfg.Admins),
+		newGroupAdminAuthorizer(messenger.ChatAdmins, groupAdminsTTL),
+	}
+	return &Bot{
+		messenger:       messenger,
+		router:          newCommandRouter(),
+		quit:            make(chan struct{}),
+		outputStop:      make(chan struct{}),
+		outputDone:      make(chan struct{}),
+		Store:           store,
+		Sessions:        newSessionManager(),
+		Output:          make(chan Msg),
+		GradeScale:      cfg.gradeScale,
+		DialogTimeout:   cfg.DialogTimeout,
+		ReminderOffsets: cfg.ReminderOffsets,
+		OutboundLimits:  cfg.Outbound,
+		Authorizer:      auth,
+		Limiter:         newBucketLimiter(cfg.Limits),
+		Limits:          cfg.Limits,
+		scheduled:       make(map[reminderKey]bool),
+	}
 
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testMessenger is a Messenger which records sent messages, so handlers can be tested without telegram. If fail is set, it decides which messages can't be sent.
+type testMessenger struct {
+	sent chan Msg
+	fail func(m Msg) error
+}
+
+func newTestMessenger() *testMessenger {
+	return &testMessenger{sent: make(chan Msg, 100)}
+}
+
+func (tm *testMessenger) record(m Msg) error {
+	if tm.fail != nil {
+		if err := tm.fail(m); err != nil {
+			return err
+		}
+	}
+	tm.sent <- m
+	return nil
+}
+
+func (tm *testMessenger) Send(chat chatid, text string, format textFormat) error {
+	return tm.record(Msg{chatID: chat, text: text, format: format})
+}
+
+func (tm *testMessenger) SendKeyboard(chat chatid, text string, format textFormat, options []string) error {
+	return tm.record(Msg{chatID: chat, text: text, format: format, keyboard: options})
+}
+
+func (tm *testMessenger) SendDocument(chat chatid, fileName string, data []byte, caption string) error {
+	return tm.record(Msg{chatID: chat, text: caption})
+}
+
+func (tm *testMessenger) SetCommands(commands []commandInfo) error { return nil }
+func (tm *testMessenger) Start(handler func(Update))               {}
+func (tm *testMessenger) Stop()                                    {}
+func (tm *testMessenger) Health() error                            { return nil }
+func (tm *testMessenger) ChatAdmins(chat chatid) ([]int64, error)  { return nil, nil }
+
+// testStorageConfig returns config of json files in given directory.
+func testStorageConfig(dir string) storageConfig {
+	return storageConfig{
+		FlashcardsFile: filepath.Join(dir, flashcardsFileName),
+		RemindersFile:  filepath.Join(dir, remindersFileName),
+		SchedulesFile:  filepath.Join(dir, schedulesFileName),
+		OutboxFile:     filepath.Join(dir, outboxFileName),
+		LanguagesFile:  filepath.Join(dir, languagesFileName),
+	}
+}
+
+// newTestStore creates json store with files in temporary directory.
+func newTestStore(t *testing.T) *jsonStore {
+	s, err := newJSONStore(testStorageConfig(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// testConfig returns default config without rate limits, so tests don't wait.
+func testConfig() *config {
+	cfg := defaultConfig()
+	cfg.gradeScale = defaultGradeScale()
+	cfg.Limits.UserRate = 0
+	cfg.Limits.ChatRate = 0
+	cfg.Outbound = outboundConfig{MaxAttempts: 1}
+	return cfg
+}
+
+// newTestBot creates bot with test config talking through test messenger.
+func newTestBot(t *testing.T) (*Bot, *testMessenger) {
+	return startTestBot(t, testConfig())
+}
+
+// startTestBot creates bot with given config talking through test messenger.
+func startTestBot(t *testing.T, cfg *config) (*Bot, *testMessenger) {
+	tm := newTestMessenger()
+	b := NewBot(tm, newTestStore(t), cfg)
+	b.registerCommands()
+	go b.HandleOutput()
+	t.Cleanup(func() { b.Shutdown(5 * time.Second) })
+	return b, tm
+}
+
+// expectSent waits for messages sent by bot and compares their texts.
+func expectSent(t *testing.T, tm *testMessenger, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case m := <-tm.sent:
+			if m.text != w {
+				t.Fatalf("sent %q, want %q", m.text, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("nothing sent, want %q", w)
+		}
+	}
+}
+
+func TestHandleUpdate(t *testing.T) {
+	lang := defaultLanguage
+	type step struct {
+		text string
+		want []string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "version",
+			steps: []step{{"/version", []string{lang.T("version", version)}}},
+		},
+		{
+			name:  "command with bot name",
+			steps: []step{{"/version@assistant_bot", []string{lang.T("version", version)}}},
+		},
+		{
+			name:  "flashcard without term",
+			steps: []step{{"/fiszka", []string{lang.T("flashcard.termMissing")}}},
+		},
+		{
+			name: "add and show flashcard",
+			steps: []step{
+				{"/dodajfiszke", []string{lang.T("topic.ask")}},
+				{"Biologia", []string{lang.T("flashcard.askTerm")}},
+				{"Mitochondrium", []string{lang.T("flashcard.askDefinition")}},
+				{"centrum energetyczne <komórki>", []string{lang.T("flashcard.added")}},
+				{"/fiszka mitochondrium", []string{"\n<b>Biologia, Mitochondrium</b> - centrum energetyczne &lt;komórki&gt;"}},
+			},
+		},
+		{
+			name: "back and cancel dialog",
+			steps: []step{
+				{"/dodajfiszke", []string{lang.T("topic.ask")}},
+				{"chemia", []string{lang.T("flashcard.askTerm")}},
+				{backCommand, []string{lang.T("topic.ask")}},
+				{"fizyka", []string{lang.T("flashcard.askTerm")}},
+				{cancelCommand, []string{lang.T("dialog.cancelled")}},
+				{cancelCommand, []string{lang.T("dialog.nothingToCancel")}},
+			},
+		},
+		{
+			name: "language from payload doesn't cancel dialog",
+			steps: []step{
+				{"/dodajfiszke", []string{lang.T("topic.ask")}},
+				{"/jezyk en", []string{english.T("language.set")}},
+				{"chemia", []string{lang.T("flashcard.askTerm")}},
+			},
+		},
+		{
+			name:  "back without dialog",
+			steps: []step{{backCommand, []string{lang.T("dialog.noDialog")}}},
+		},
+		{
+			name:  "export data",
+			steps: []step{{"/moje_dane", []string{lang.T("data.exportCaption")}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, tm := newTestBot(t)
+			for _, s := range tt.steps {
+				b.HandleUpdate(Update{ChatID: 1, UserID: 1, Text: s.text})
+				expectSent(t, tm, s.want...)
+			}
+		})
+	}
+}
+
+func TestRefusedCommandsAreRateLimited(t *testing.T) {
+	cfg := testConfig()
+	cfg.Access = accessConfig{Users: []int64{1}}
+	cfg.Limits.UserRate, cfg.Limits.UserBurst = 60, 2
+	b, tm := startTestBot(t, cfg)
+	lang := defaultLanguage
+
+	for i := 0; i < 2; i++ {
+		b.HandleUpdate(Update{ChatID: 2, UserID: 2, Text: "/version"})
+		expectSent(t, tm, lang.T("error.notAuthorized"))
+	}
+	b.HandleUpdate(Update{ChatID: 2, UserID: 2, Text: "/version"})
+	expectSent(t, tm, lang.T("error.rateLimited", 1))
+	b.HandleUpdate(Update{ChatID: 2, UserID: 2, Text: "/version"})
+	select {
+	case m := <-tm.sent:
+		t.Fatalf("sent %q to throttled stranger", m.text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRefusedCommandsAreNotCounted(t *testing.T) {
+	cfg := testConfig()
+	cfg.Access = accessConfig{Users: []int64{1}}
+	b, tm := startTestBot(t, cfg)
+	handled := commandsHandled.WithLabelValues("/version")
+
+	before := testutil.ToFloat64(handled)
+	b.HandleUpdate(Update{ChatID: 2, UserID: 2, Text: "/version"})
+	expectSent(t, tm, defaultLanguage.T("error.notAuthorized"))
+	b.HandleUpdate(Update{ChatID: 1, UserID: 1, Text: "/version"})
+	expectSent(t, tm, defaultLanguage.T("version", version))
+	if got := testutil.ToFloat64(handled) - before; got != 1 {
+		t.Errorf("counted %v handled commands, want 1", got)
+	}
+}
+
+func TestKnowledgeTestBack(t *testing.T) {
+	b, tm := newTestBot(t)
+	lang := defaultLanguage
+	// definitions are the same as terms, so test knows answer from question, whatever order questions come in
+	for _, term := range []string{"kwas", "zasada"} {
+		if err := b.Store.PutFlashcard(1, "chemia", term, term); err != nil {
+			t.Fatal(err)
+		}
+	}
+	say := func(text string) {
+		b.HandleUpdate(Update{ChatID: 1, UserID: 1, Text: text})
+	}
+	question := func() string {
+		t.Helper()
+		select {
+		case m := <-tm.sent:
+			for _, term := range []string{"kwas", "zasada"} {
+				if m.text == lang.T("test.question", term) {
+					return term
+				}
+			}
+			t.Fatalf("sent %q, want question", m.text)
+		case <-time.After(5 * time.Second):
+			t.Fatal("nothing sent, want question")
+		}
+		return ""
+	}
+
+	say("/test")
+	expectSent(t, tm, lang.T("test.intro"))
+	say("chemia")
+	expectSent(t, tm, lang.T("test.askRange", 2))
+	say("2")
+
+	first := question()
+	say("nie wiem")
+	expectSent(t, tm, lang.T("test.wrong", strings.Title(first)))
+	question()
+	say(backCommand)
+	if again := question(); again != first {
+		t.Fatalf("back asked %q, want %q again", again, first)
+	}
+	say(first)
+	expectSent(t, tm, lang.T("test.correct"))
+	second := question()
+	say(second)
+	expectSent(t, tm, lang.T("test.correct"), lang.T("test.result", 2, 2))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxMessageLength is the longest text telegram accepts in one message. Telegram counts it in UTF-16 code units, without formatting tags.
+const maxMessageLength = 4096
+
+// runeLength returns how many UTF-16 code units rune takes.
+func runeLength(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// textLength returns length of text counted like telegram does.
+func textLength(text string) int {
+	n := 0
+	for _, r := range text {
+		n += runeLength(r)
+	}
+	return n
+}
+
+// chunkToken is a piece of message which can't be split: a rune, or in HTML messages a whole tag or entity.
+// Length is how long token is in text shown to user, tags have length 0.
+type chunkToken struct {
+	text   string
+	length int
+	tag    bool
+}
+
+// tokenize splits text into tokens. Plain text has only runes, so "<" in user's text is never treated as a tag.
+func tokenize(text string, format textFormat) []chunkToken {
+	tokens := []chunkToken{}
+	for len(text) > 0 {
+		if format == formatHTML {
+			if text[0] == '<' {
+				if end := strings.IndexByte(text, '>'); end != -1 {
+					tokens = append(tokens, chunkToken{text: text[:end+1], tag: true})
+					text = text[end+1:]
+					continue
+				}
+			}
+			if text[0] == '&' {
+				if end := strings.IndexByte(text, ';'); end != -1 && end <= 10 {
+					entity := text[:end+1]
+					tokens = append(tokens, chunkToken{text: entity, length: textLength(html.UnescapeString(entity))})
+					text = text[end+1:]
+					continue
+				}
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(text)
+		tokens = append(tokens, chunkToken{text: text[:size], length: runeLength(r)})
+		text = text[size:]
+	}
+	return tokens
+}
+
+// tagName returns name of HTML tag and if it is a closing tag, e.g. "a" and false for `<a href="...">`.
+func tagName(tag string) (string, bool) {
+	name := strings.Trim(tag, "<>")
+	closing := strings.HasPrefix(name, "/")
+	name = strings.TrimPrefix(name, "/")
+	if i := strings.IndexFunc(name, unicode.IsSpace); i != -1 {
+		name = name[:i]
+	}
+	return name, closing
+}
+
+// splitText splits text into parts not longer than limit. Text is split on line boundaries when possible, so lines of schedule or reminders list are not broken, then on spaces, and only words longer than limit are cut.
+// In HTML messages tags and entities are never cut. Tags opened in one part are closed at its end and opened again in the next part, so formatting is kept.
+func splitText(text string, limit int, format textFormat) []string {
+	tokens := tokenize(text, format)
+	length := 0
+	for _, t := range tokens {
+		length += t.length
+	}
+	if length <= limit {
+		return []string{text}
+	}
+
+	parts := []string{}
+	open := []string{}
+	for len(tokens) > 0 {
+		n, partLength, lastLine, lastSpace := 0, 0, 0, 0
+		for n < len(tokens) && partLength+tokens[n].length <= limit {
+			partLength += tokens[n].length
+			switch tokens[n].text {
+			case "\n":
+				lastLine = n + 1
+			case " ", "\t":
+				lastSpace = n + 1
+			}
+			n++
+		}
+		if n < len(tokens) {
+			if lastLine > 0 {
+				n = lastLine
+			} else if lastSpace > 0 {
+				n = lastSpace
+			}
+		}
+
+		var body strings.Builder
+		opening := strings.Join(open, "")
+		for _, t := range tokens[:n] {
+			body.WriteString(t.text)
+			if !t.tag {
+				continue
+			}
+			if name, closing := tagName(t.text); !closing {
+				open = append(open, t.text)
+			} else if len(open) > 0 {
+				if openName, _ := tagName(open[len(open)-1]); openName == name {
+					open = open[:len(open)-1]
+				}
+			}
+		}
+		tokens = tokens[n:]
+
+		closingTags := ""
+		for i := len(open) - 1; i >= 0; i-- {
+			name, _ := tagName(open[i])
+			closingTags += "</" + name + ">"
+		}
+		if p := strings.TrimRight(body.String(), "\n"); strings.TrimSpace(p) != "" {
+			parts = append(parts, opening+p+closingTags)
+		}
+	}
+	return parts
+}
+
+// splitMsg splits message which is too long for telegram into messages sent one after another. Keyboard and pending ID stay only with the last part, so keyboard is shown under the whole text and message is deleted from store after all parts are delivered.
+func splitMsg(m Msg) []Msg {
+	// caption can't be split from its document
+	if m.document != nil {
+		return []Msg{m}
+	}
+	texts := splitText(m.text, maxMessageLength, m.format)
+	if len(texts) == 1 {
+		return []Msg{m}
+	}
+
+	msgs := make([]Msg, 0, len(texts))
+	for _, text := range texts {
+		msgs = append(msgs, Msg{chatID: m.chatID, text: text, format: m.format})
+	}
+	msgs[len(msgs)-1].keyboard = m.keyboard
+	msgs[len(msgs)-1].pendingID = m.pendingID
+	return msgs
+}
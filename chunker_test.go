@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTextLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"kot", 3},
+		{"żółw", 4},
+		{"😀", 2},
+		{"a😀b", 4},
+	}
+
+	for _, tt := range tests {
+		if got := textLength(tt.text); got != tt.want {
+			t.Errorf("textLength(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		limit  int
+		format textFormat
+		want   []string
+	}{
+		{"short", "ala ma kota", 20, formatPlain, []string{"ala ma kota"}},
+		{"exact limit", "abcd", 4, formatPlain, []string{"abcd"}},
+		{"lines", "aaa\nbbb\nccc", 8, formatPlain, []string{"aaa\nbbb", "ccc"}},
+		{"spaces", "ala ma kota", 7, formatPlain, []string{"ala ma ", "kota"}},
+		{"long word", "abcdefghij", 4, formatPlain, []string{"abcd", "efgh", "ij"}},
+		{"empty lines are dropped", "aaa\n\n\nbbb", 4, formatPlain, []string{"aaa", "bbb"}},
+		{"emoji is not cut", "😀😀😀", 5, formatPlain, []string{"😀😀", "😀"}},
+		{"tags are not counted", "<b>abc</b>", 3, formatHTML, []string{"<b>abc</b>"}},
+		{"tags are reopened", "<b>aaaa bbbb</b>", 5, formatHTML, []string{"<b>aaaa </b>", "<b>bbbb</b>"}},
+		{"nested tags", "<b><i>aaaa bbbb</i></b>", 5, formatHTML, []string{"<b><i>aaaa </i></b>", "<b><i>bbbb</i></b>"}},
+		{"tag with attributes", `<a href="x">aaaa bbbb</a>`, 5, formatHTML, []string{`<a href="x">aaaa </a>`, `<a href="x">bbbb</a>`}},
+		{"entities are not cut", "&amp;&amp;&amp;", 2, formatHTML, []string{"&amp;&amp;", "&amp;"}},
+		{"plain text has no tags", "<b>x", 3, formatPlain, []string{"<b>", "x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitText(tt.text, tt.limit, tt.format)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitText(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMsg(t *testing.T) {
+	short := Msg{chatID: 1, text: "kot", keyboard: []string{"tak"}, pendingID: "1"}
+	if got := splitMsg(short); !reflect.DeepEqual(got, []Msg{short}) {
+		t.Errorf("short message was changed: %#v", got)
+	}
+
+	long := Msg{chatID: 1, text: strings.Repeat("a", maxMessageLength+10), keyboard: []string{"tak"}, pendingID: "1"}
+	got := splitMsg(long)
+	if len(got) != 2 {
+		t.Fatalf("got %d parts, want 2", len(got))
+	}
+	if textLength(got[0].text) != maxMessageLength || textLength(got[1].text) != 10 {
+		t.Errorf("parts have length %d and %d", textLength(got[0].text), textLength(got[1].text))
+	}
+	if got[0].keyboard != nil || got[0].pendingID != "" {
+		t.Error("keyboard or pending ID is in the first part")
+	}
+	if !reflect.DeepEqual(got[1].keyboard, long.keyboard) || got[1].pendingID != long.pendingID {
+		t.Error("keyboard or pending ID is missing in the last part")
+	}
+
+	doc := Msg{chatID: 1, text: long.text, document: &document{fileName: "chat-1.json"}}
+	if got := splitMsg(doc); len(got) != 1 || got[0].document == nil {
+		t.Error("caption was split from document")
+	}
+}
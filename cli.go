@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cliCommand is subcommand given as the first argument of program, e.g. "student-assistant-bot validate".
+// Args are names of arguments which have to be given after flags.
+// Telegram means command talks with telegram, so telegram settings are required.
+// Bot means command runs bot, so it logs like bot does, other commands log to terminal.
+type cliCommand struct {
+	Name        string
+	Args        []string
+	Description string
+	Telegram    bool
+	Bot         bool
+	Run         func(cfg *config, args []string) error
+}
+
+// cliCommands returns all subcommands in order in which they are shown in usage.
+func cliCommands() []cliCommand {
+	return []cliCommand{
+		{Name: "serve", Description: "run telegram bot (default)", Telegram: true, Bot: true, Run: func(cfg *config, args []string) error {
+			return runBot(cfg, false)
+		}},
+		{Name: "repl", Description: "run bot in terminal", Bot: true, Run: func(cfg *config, args []string) error {
+			return runBot(cfg, true)
+		}},
+		{Name: "validate", Description: "check that data and its backups can be read and are consistent, without changing them", Run: func(cfg *config, args []string) error {
+			return validateData(cfg.Storage)
+		}},
+		{Name: "stats", Description: "print how much data is stored, without changing it", Run: func(cfg *config, args []string) error {
+			return printStats(cfg.Storage)
+		}},
+		{Name: "migrate", Description: "migrate data to current format, bot has to be stopped", Run: func(cfg *config, args []string) error {
+			return migrateData(cfg.Storage)
+		}},
+		{Name: "export", Args: []string{"{archive}"}, Description: "write all data to backup archive, without changing it", Run: func(cfg *config, args []string) error {
+			return exportData(cfg.Storage, args[0])
+		}},
+		{Name: "import", Args: []string{"{archive}"}, Description: "read all data from backup archive into empty storage", Run: func(cfg *config, args []string) error {
+			return importData(cfg.Storage, args[0])
+		}},
+		{Name: "importjson", Description: "copy data from json files into empty sqlite database", Run: func(cfg *config, args []string) error {
+			return importJSON(cfg.Storage)
+		}},
+		{Name: "encrypt", Description: "encrypt all data with the first key, also used to rotate keys", Run: func(cfg *config, args []string) error {
+			return recryptData(cfg.Storage, false)
+		}},
+		{Name: "decrypt", Description: "write all data as plaintext", Run: func(cfg *config, args []string) error {
+			return recryptData(cfg.Storage, true)
+		}},
+	}
+}
+
+// findCLICommand returns subcommand with given name.
+func findCLICommand(name string) (cliCommand, bool) {
+	for _, c := range cliCommands() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return cliCommand{}, false
+}
+
+// printUsage writes list of subcommands.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: student-assistant-bot [command] [flags] [arguments]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range cliCommands() {
+		name := strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+		fmt.Fprintf(w, "  %-20s %s\n", name, c.Description)
+	}
+	fmt.Fprintln(w, "\nRun student-assistant-bot [command] -h to see flags.")
+}
+
+// importJSON copies data from json files into sqlite database.
+func importJSON(sc storageConfig) error {
+	js, err := newJSONStore(sc)
+	if err != nil {
+		return errors.New("could not load json data: " + err.Error())
+	}
+	db, err := newSQLStore(sc.SQLitePath, sc.keys)
+	if err != nil {
+		return errors.New("could not open database: " + err.Error())
+	}
+	defer db.Close()
+
+	if err := db.ImportJSON(js); err != nil {
+		return errors.New("could not import data: " + err.Error())
+	}
+	log.Info("Imported json files into " + sc.SQLitePath)
+	return nil
+}
+
+// recryptData encrypts all data of configured backend with primary key, or decrypts it if decrypt is true. It is also used to rotate keys: after new key is added as the first one, data encrypted with old keys is encrypted again with the new one. Bot can't run at the same time, because it would overwrite files with data it keeps in memory.
+func recryptData(sc storageConfig, decrypt bool) error {
+	if sc.keys == nil {
+		return errors.New("no encryption key is configured, set encryptionKeys env variable or storage.encryption.keyFile")
+	}
+	keys := sc.keys
+	if decrypt {
+		keys = keys.decryptOnly()
+	}
+
+	if sc.Backend == "sqlite" {
+		db, err := newSQLStore(sc.SQLitePath, keys)
+		if err != nil {
+			return errors.New("could not open database: " + err.Error())
+		}
+		defer db.Close()
+		if err := db.Recrypt(); err != nil {
+			return errors.New("could not rewrite data: " + err.Error())
+		}
+		log.Info("Rewrote data in " + sc.SQLitePath)
+		return nil
+	}
+
+	for _, fileName := range []string{sc.FlashcardsFile, sc.RemindersFile, sc.SchedulesFile, sc.OutboxFile, sc.LanguagesFile} {
+		files := []string{fileName}
+		for n := 1; n <= sc.Backups; n++ {
+			files = append(files, backupFileName(fileName, n))
+		}
+		for _, f := range files {
+			if err := recryptDataFile(f, keys); err != nil {
+				return errors.New("could not rewrite file: " + err.Error())
+			}
+		}
+	}
+	log.Info("Rewrote json files and their backups")
+	return nil
+}
+
+// exportData writes all data of configured backend to backup archive. Data is only read, never migrated or restored from backups, so it can be exported while bot is running.
+func exportData(sc storageConfig, fileName string) error {
+	s, err := openStoreReadOnly(sc)
+	if err != nil {
+		return errors.New("could not load data: " + err.Error())
+	}
+	defer s.Close()
+
+	if err := exportBackup(s, fileName, sc.keys); err != nil {
+		return errors.New("could not export data: " + err.Error())
+	}
+	return nil
+}
+
+// importData reads backup archive into configured backend. Storage has to be empty, so backup can't overwrite newer data, and bot can't run at the same time.
+func importData(sc storageConfig, fileName string) error {
+	js, err := readBackup(fileName, sc.keys)
+	if err != nil {
+		return errors.New("could not read backup: " + err.Error())
+	}
+
+	if sc.Backend == "sqlite" {
+		var db *sqlStore
+		if db, err = newSQLStore(sc.SQLitePath, sc.keys); err != nil {
+			return errors.New("could not open database: " + err.Error())
+		}
+		defer db.Close()
+		err = db.ImportJSON(js)
+	} else {
+		var s *jsonStore
+		if s, err = newJSONStore(sc); err != nil {
+			return errors.New("could not load json data: " + err.Error())
+		}
+		defer s.Close()
+		err = s.ImportJSON(js)
+	}
+	if err != nil {
+		return errors.New("could not import data: " + err.Error())
+	}
+	log.Info("Imported " + fileName)
+	return nil
+}
+
+// printStats prints how much data of every kind is stored. Like export, it only reads data.
+func printStats(sc storageConfig) error {
+	s, err := openStoreReadOnly(sc)
+	if err != nil {
+		return errors.New("could not load data: " + err.Error())
+	}
+	defer s.Close()
+
+	st, err := s.Stats()
+	if err != nil {
+		return errors.New("could not count data: " + err.Error())
+	}
+	fmt.Printf("chats: %d\ntopics: %d\nflashcards: %d\nreminders: %d\nclasses: %d\npending messages: %d\n",
+		st.Chats, st.Topics, st.Flashcards, st.Reminders, st.Classes, st.PendingMessages)
+	return nil
+}
+
+// migrateData migrates data of configured backend to current format. Bot does it on start as well, command lets it be done before new version is started. It is the only command besides import, encrypt and decrypt which writes data, so bot has to be stopped, otherwise it would overwrite files with data it keeps in memory.
+func migrateData(sc storageConfig) error {
+	s, err := openStore(sc)
+	if err != nil {
+		return errors.New("could not migrate data: " + err.Error())
+	}
+	if err := s.Close(); err != nil {
+		return errors.New("could not write data: " + err.Error())
+	}
+	log.Info("Data is in current format")
+	return nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConfigFile = "config.yaml"
+
+// config keeps all bot settings. They are read from defaults, config file, env variables and command line flags, every next source overrides the previous one.
+// Token is telegram bot token.
+// Env is "dev" or "prod", in prod logs are written as json to LogFile.
+// DialogTimeout is how long bot waits for user's answer in dialog.
+// ShutdownTimeout is how long bot can take to stop after SIGINT or SIGTERM.
+// ReminderOffsets are times before reminder's date when it is sent, sorted from the longest one. Default 26h and 2h send reminders a day and on the day in UTC+2.
+// GradeScale is exam grade scale in format accepted by parseGradeScale, empty means default scale.
+// Admins are telegram user IDs which can run admin commands.
+// Access limits who can use bot.
+// Limits protect bot from abuse.
+type config struct {
+	Token           string          `yaml:"token"`
+	Env             string          `yaml:"env"`
+	LogFile         string          `yaml:"logFile"`
+	DialogTimeout   time.Duration   `yaml:"dialogTimeout"`
+	ShutdownTimeout time.Duration   `yaml:"shutdownTimeout"`
+	ReminderOffsets []time.Duration `yaml:"reminderOffsets"`
+	GradeScale      string          `yaml:"gradeScale"`
+	Admins          []int64         `yaml:"admins"`
+	Access          accessConfig    `yaml:"access"`
+	Limits          limitsConfig    `yaml:"limits"`
+	Storage         storageConfig   `yaml:"storage"`
+	Telegram        telegramConfig  `yaml:"telegram"`
+	Outbound        outboundConfig  `yaml:"outbound"`
+	Metrics         metricsConfig   `yaml:"metrics"`
+
+	gradeScale gradeScale
+}
+
+// storageConfig chooses storage backend and its files.
+// Backend is "json" or "sqlite".
+// Backups is how many previous versions of every json file are kept.
+// Encryption tells where keys for encrypting data are, keys are loaded from it when config is validated.
+type storageConfig struct {
+	Backend        string           `yaml:"backend"`
+	FlashcardsFile string           `yaml:"flashcardsFile"`
+	RemindersFile  string           `yaml:"remindersFile"`
+	SchedulesFile  string           `yaml:"schedulesFile"`
+	OutboxFile     string           `yaml:"outboxFile"`
+	LanguagesFile  string           `yaml:"languagesFile"`
+	Backups        int              `yaml:"backups"`
+	SQLitePath     string           `yaml:"sqlitePath"`
+	Encryption     encryptionConfig `yaml:"encryption"`
+
+	keys *keyring
+}
+
+// outboundConfig limits how fast messages are sent, default values match telegram limits.
+// GlobalRate is how many messages can be sent per second to all chats.
+// ChatInterval and GroupInterval are minimal times between messages sent to the same private chat or group.
+// MaxAttempts is how many times message is sent before it is dropped, messages kept in store are retried until delivered.
+type outboundConfig struct {
+	GlobalRate    float64       `yaml:"globalRate"`
+	ChatInterval  time.Duration `yaml:"chatInterval"`
+	GroupInterval time.Duration `yaml:"groupInterval"`
+	MaxAttempts   int           `yaml:"maxAttempts"`
+}
+
+// telegramConfig chooses how updates are received from telegram.
+type telegramConfig struct {
+	Mode    string        `yaml:"mode"`
+	Webhook webhookConfig `yaml:"webhook"`
+}
+
+// defaultConfig returns settings used when nothing else is given.
+func defaultConfig() *config {
+	return &config{
+		Env:             "dev",
+		LogFile:         "logrus.log",
+		DialogTimeout:   10 * time.Minute,
+		ShutdownTimeout: 10 * time.Second,
+		ReminderOffsets: []time.Duration{26 * time.Hour, 2 * time.Hour},
+		Storage: storageConfig{
+			Backend:        "json",
+			FlashcardsFile: flashcardsFileName,
+			RemindersFile:  remindersFileName,
+			SchedulesFile:  schedulesFileName,
+			OutboxFile:     outboxFileName,
+			LanguagesFile:  languagesFileName,
+			Backups:        defaultDataBackups,
+			SQLitePath:     defaultSQLitePath,
+		},
+		Telegram: telegramConfig{
+			Mode: pollingMode,
+		},
+		Outbound: outboundConfig{
+			GlobalRate:    30,
+			ChatInterval:  time.Second,
+			GroupInterval: 3 * time.Second,
+			MaxAttempts:   5,
+		},
+		Limits: limitsConfig{
+			UserRate:      20,
+			UserBurst:     5,
+			ChatRate:      40,
+			ChatBurst:     10,
+			MaxFlashcards: 2000,
+			MaxReminders:  100,
+			MaxTextLength: 1000,
+		},
+	}
+}
+
+// loadConfig reads settings from all sources and validates them. Telegram settings are required only if bot talks with telegram. Arguments left after flags are returned.
+func loadConfig(args []string, needsTelegram bool) (*config, []string, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("student-assistant-bot", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to yaml config file (default "+defaultConfigFile+" if it exists)")
+	env := fs.String("env", "", "dev or prod")
+	logFile := fs.String("log-file", "", "log file used in prod")
+	dialogTimeout := fs.Duration("dialog-timeout", 0, "how long bot waits for answer in dialog, e.g. 10m")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long bot can take to stop, e.g. 10s")
+	backend := fs.String("storage", "", "storage backend, json or sqlite")
+	sqlitePath := fs.String("sqlite-path", "", "sqlite database file")
+	mode := fs.String("telegram-mode", "", "polling or webhook")
+	metricsListen := fs.String("metrics-listen", "", "address of metrics and health server, e.g. :9090")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("configFile")
+	}
+	if err := cfg.readFile(path); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.readEnv(); err != nil {
+		return nil, nil, err
+	}
+
+	// only flags given by user override other sources
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			cfg.Env = *env
+		case "log-file":
+			cfg.LogFile = *logFile
+		case "dialog-timeout":
+			cfg.DialogTimeout = *dialogTimeout
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = *shutdownTimeout
+		case "storage":
+			cfg.Storage.Backend = *backend
+		case "sqlite-path":
+			cfg.Storage.SQLitePath = *sqlitePath
+		case "telegram-mode":
+			cfg.Telegram.Mode = *mode
+		case "metrics-listen":
+			cfg.Metrics.Listen = *metricsListen
+		}
+	})
+
+	if err := cfg.validate(needsTelegram); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// readFile reads yaml config file. If path is empty, default file is read only if it exists. Unknown keys are errors, so typos don't go unnoticed.
+func (cfg *config) readFile(path string) error {
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err != nil {
+			return nil
+		}
+		path = defaultConfigFile
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.New("could not open config file: " + err.Error())
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err = decoder.Decode(cfg); err != nil && err != io.EOF {
+		return errors.New("could not parse config file " + path + ": " + err.Error())
+	}
+	return nil
+}
+
+// readEnv overrides settings with env variables which are set.
+func (cfg *config) readEnv() error {
+	vars := map[string]*string{
+		"telegramBot":           &cfg.Token,
+		"botEnv":                &cfg.Env,
+		"logFile":               &cfg.LogFile,
+		"examGradeScale":        &cfg.GradeScale,
+		"storageBackend":        &cfg.Storage.Backend,
+		"flashcardsFile":        &cfg.Storage.FlashcardsFile,
+		"remindersFile":         &cfg.Storage.RemindersFile,
+		"schedulesFile":         &cfg.Storage.SchedulesFile,
+		"outboxFile":            &cfg.Storage.OutboxFile,
+		"languagesFile":         &cfg.Storage.LanguagesFile,
+		"sqlitePath":            &cfg.Storage.SQLitePath,
+		"telegramMode":          &cfg.Telegram.Mode,
+		"telegramWebhookListen": &cfg.Telegram.Webhook.Listen,
+		"telegramWebhookPath":   &cfg.Telegram.Webhook.Path,
+		"telegramWebhookURL":    &cfg.Telegram.Webhook.URL,
+		"telegramWebhookSecret": &cfg.Telegram.Webhook.Secret,
+		"telegramWebhookCert":   &cfg.Telegram.Webhook.CertFile,
+		"telegramWebhookKey":    &cfg.Telegram.Webhook.KeyFile,
+		"metricsListen":         &cfg.Metrics.Listen,
+		"encryptionKeyFile":     &cfg.Storage.Encryption.KeyFile,
+		"encryptionKeys":        &cfg.Storage.Encryption.Keys,
+	}
+	for name, dst := range vars {
+		if v := os.Getenv(name); v != "" {
+			*dst = v
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"dialogTimeout":   &cfg.DialogTimeout,
+		"shutdownTimeout": &cfg.ShutdownTimeout,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return errors.New(name + " env: " + err.Error())
+			}
+			*dst = d
+		}
+	}
+	if v := os.Getenv("reminderOffsets"); v != "" {
+		offsets, err := parseDurations(v)
+		if err != nil {
+			return errors.New("reminderOffsets env: " + err.Error())
+		}
+		cfg.ReminderOffsets = offsets
+	}
+	if v := os.Getenv("botAdmins"); v != "" {
+		admins, err := parseIDs(v)
+		if err != nil {
+			return errors.New("botAdmins env: " + err.Error())
+		}
+		cfg.Admins = admins
+	}
+	ids := map[string]*[]int64{
+		"allowedUsers": &cfg.Access.Users,
+		"allowedChats": &cfg.Access.Chats,
+	}
+	for name, dst := range ids {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := parseIDs(v)
+			if err != nil {
+				return errors.New(name + " env: " + err.Error())
+			}
+			*dst = parsed
+		}
+	}
+	if v := os.Getenv("dataBackups"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("dataBackups env: " + err.Error())
+		}
+		cfg.Storage.Backups = n
+	}
+	return nil
+}
+
+// parseDurations parses comma separated durations, e.g. "26h,2h".
+func parseDurations(s string) ([]time.Duration, error) {
+	durations := []time.Duration{}
+	for _, part := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		durations = append(durations, d)
+	}
+	return durations, nil
+}
+
+// parseIDs parses comma separated user or chat IDs, e.g. "123,-456".
+func parseIDs(s string) ([]int64, error) {
+	ids := []int64{}
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// validate checks all settings and returns one error listing every problem.
+func (cfg *config) validate(needsTelegram bool) error {
+	problems := []string{}
+
+	if cfg.Env != "dev" && cfg.Env != "prod" {
+		problems = append(problems, "env must be dev or prod, got "+strconv.Quote(cfg.Env))
+	}
+	if cfg.Env == "prod" && cfg.LogFile == "" {
+		problems = append(problems, "logFile is required in prod")
+	}
+	if cfg.DialogTimeout <= 0 {
+		problems = append(problems, "dialogTimeout must be positive")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdownTimeout must be positive")
+	}
+
+	if len(cfg.ReminderOffsets) == 0 {
+		problems = append(problems, "reminderOffsets needs at least one offset")
+	}
+	for _, offset := range cfg.ReminderOffsets {
+		if offset < 0 {
+			problems = append(problems, "reminderOffsets can't be negative, got "+offset.String())
+		}
+	}
+	sort.Slice(cfg.ReminderOffsets, func(i, j int) bool {
+		return cfg.ReminderOffsets[i] > cfg.ReminderOffsets[j]
+	})
+
+	cfg.gradeScale = defaultGradeScale()
+	if cfg.GradeScale != "" {
+		gs, err := parseGradeScale(cfg.GradeScale)
+		if err != nil {
+			problems = append(problems, "gradeScale: "+err.Error())
+		}
+		cfg.gradeScale = gs
+	}
+
+	switch cfg.Storage.Backend {
+	case "json":
+		if cfg.Storage.FlashcardsFile == "" || cfg.Storage.RemindersFile == "" || cfg.Storage.SchedulesFile == "" || cfg.Storage.OutboxFile == "" || cfg.Storage.LanguagesFile == "" {
+			problems = append(problems, "storage files can't be empty")
+		}
+		if cfg.Storage.Backups < 0 {
+			problems = append(problems, "storage backups can't be negative")
+		}
+	case "sqlite":
+		if cfg.Storage.SQLitePath == "" {
+			problems = append(problems, "storage sqlitePath can't be empty")
+		}
+	default:
+		problems = append(problems, "storage backend must be json or sqlite, got "+strconv.Quote(cfg.Storage.Backend))
+	}
+
+	keys, err := loadKeyring(cfg.Storage.Encryption)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	cfg.Storage.keys = keys
+
+	if cfg.Outbound.GlobalRate < 0 || cfg.Outbound.ChatInterval < 0 || cfg.Outbound.GroupInterval < 0 {
+		problems = append(problems, "outbound limits can't be negative")
+	}
+	if cfg.Outbound.MaxAttempts < 1 {
+		problems = append(problems, "outbound maxAttempts must be at least 1")
+	}
+
+	if cfg.Limits.UserRate < 0 || cfg.Limits.ChatRate < 0 || cfg.Limits.MaxFlashcards < 0 || cfg.Limits.MaxReminders < 0 || cfg.Limits.MaxTextLength < 0 {
+		problems = append(problems, "limits can't be negative")
+	}
+	if (cfg.Limits.UserRate > 0 && cfg.Limits.UserBurst < 1) || (cfg.Limits.ChatRate > 0 && cfg.Limits.ChatBurst < 1) {
+		problems = append(problems, "limits userBurst and chatBurst must be at least 1")
+	}
+
+	if needsTelegram {
+		if cfg.Token == "" {
+			problems = append(problems, "telegram token is required, set it in config file or telegramBot env variable")
+		}
+		switch cfg.Telegram.Mode {
+		case pollingMode:
+		case webhookMode:
+			if err := cfg.Telegram.Webhook.validate(); err != nil {
+				problems = append(problems, err.Error())
+			}
+		default:
+			problems = append(problems, "telegram mode must be "+pollingMode+" or "+webhookMode+", got "+strconv.Quote(cfg.Telegram.Mode))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
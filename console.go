@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+)
+
+// localChatID is a chat ID used by console front-end, so local data doesn't mix with telegram chats.
+const localChatID chatid = 1
+
+// consoleMessenger is a Messenger which reads messages from terminal and prints bot's replies. It always uses localChatID.
+type consoleMessenger struct {
+	in   io.Reader
+	out  io.Writer
+	mu   sync.Mutex
+	stop chan struct{}
+	// Language returns language of chat for texts added by console itself, default language is used if it is nil
+	Language func(chat chatid) language
+}
+
+// newConsoleMessenger creates console messenger reading from in and writing to out.
+func newConsoleMessenger(in io.Reader, out io.Writer) *consoleMessenger {
+	return &consoleMessenger{in: in, out: out, stop: make(chan struct{})}
+}
+
+// print writes bot's reply to output. It is guarded by mutex, because replies are sent from many goroutines.
+func (c *consoleMessenger) print(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := fmt.Fprintln(c.out, "bot> "+text)
+	return err
+}
+
+// Send prints text message. Terminal can't show HTML formatting, so tags are removed.
+func (c *consoleMessenger) Send(chat chatid, text string, format textFormat) error {
+	if format == formatHTML {
+		text = stripHTML(text)
+	}
+	return c.print(text)
+}
+
+// SendKeyboard prints text message and numbered list of options.
+func (c *consoleMessenger) SendKeyboard(chat chatid, text string, format textFormat, options []string) error {
+	if format == formatHTML {
+		text = stripHTML(text)
+	}
+	for i, o := range options {
+		text += "\n  [" + strconv.Itoa(i+1) + "] " + o
+	}
+	return c.print(text)
+}
+
+// SendDocument saves document in current directory and prints its name.
+func (c *consoleMessenger) SendDocument(chat chatid, fileName string, data []byte, caption string) error {
+	err := ioutil.WriteFile(fileName, data, 0600)
+	if err != nil {
+		return err
+	}
+	lang := defaultLanguage
+	if c.Language != nil {
+		lang = c.Language(chat)
+	}
+	return c.print(caption + " " + lang.T("console.fileSaved", fileName))
+}
+
+// ChatAdmins returns the only console user, terminal chat is never a group anyway.
+func (c *consoleMessenger) ChatAdmins(chat chatid) ([]int64, error) {
+	return []int64{int64(localChatID)}, nil
+}
+
+// Health always reports that console works.
+func (c *consoleMessenger) Health() error {
+	return nil
+}
+
+// SetCommands does nothing, commands are listed by /help.
+func (c *consoleMessenger) SetCommands(commands []commandInfo) error {
+	return nil
+}
+
+// Start reads lines from input and passes them to handler until input ends or Stop is called.
+func (c *consoleMessenger) Start(handler func(Update)) {
+	// reading is done in separate goroutine, because Scan blocks until next line and Stop has to work without it
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(c.in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if line == "" {
+				continue
+			}
+			handler(Update{ChatID: localChatID, UserID: int64(localChatID), Text: line})
+		}
+	}
+}
+
+// Stop stops reading input.
+func (c *consoleMessenger) Stop() {
+	close(c.stop)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Kinds of json data files. Every kind has its own list of migrations.
+const (
+	flashcardsKind = "flashcards"
+	remindersKind  = "reminders"
+	schedulesKind  = "schedules"
+	outboxKind     = "outbox"
+	languagesKind  = "languages"
+)
+
+// dataEnvelope wraps content of every json data file, so format of data can be changed later.
+type dataEnvelope struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// dataMigration upgrades data of a file by one version.
+type dataMigration func(data json.RawMessage) (json.RawMessage, error)
+
+// dataMigrations keeps migrations of every kind of data file. Migration at index i upgrades version i to i+1, so current version of kind is number of its migrations. Never change migration which was released, add a new one instead.
+// Outbox and languages files were added after versioning, they never had legacy format, so they start at version 0.
+var dataMigrations = map[string][]dataMigration{
+	flashcardsKind: {migrateLegacyData},
+	remindersKind:  {migrateLegacyData},
+	schedulesKind:  {migrateLegacyData},
+	outboxKind:     {},
+	languagesKind:  {},
+}
+
+// migrateLegacyData upgrades files written before versioning (version 0). Their data was not wrapped in envelope, but its format didn't change, so data stays the same.
+func migrateLegacyData(data json.RawMessage) (json.RawMessage, error) {
+	return data, nil
+}
+
+// currentDataVersion returns version in which data of given kind is written.
+func currentDataVersion(kind string) int {
+	return len(dataMigrations[kind])
+}
+
+// checkDataVersion returns error if data of given kind was written in version newer than this bot knows.
+func checkDataVersion(kind string, version int) error {
+	if version > currentDataVersion(kind) {
+		return errors.New(kind + " data has version " + strconv.Itoa(version) + ", which is newer than bot supports, update the bot")
+	}
+	return nil
+}
+
+// unwrapData returns version and data of file content. Content without envelope is treated as version 0. Legacy files are maps by chat ID, so they can't have "version" key.
+func unwrapData(content []byte) (int, json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return 0, nil, err
+	}
+	if _, ok := fields["version"]; !ok {
+		return 0, content, nil
+	}
+
+	var env dataEnvelope
+	if err := json.Unmarshal(content, &env); err != nil {
+		return 0, nil, err
+	}
+	if env.Data == nil {
+		return 0, nil, errors.New("data file has version, but no data")
+	}
+	return env.Version, env.Data, nil
+}
+
+// decodeVersionedData unwraps content of data file and migrates its data to current version. It reports if any migration was applied, so file can be rewritten in current format.
+func decodeVersionedData(kind string, content []byte) (json.RawMessage, bool, error) {
+	version, data, err := unwrapData(content)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := checkDataVersion(kind, version); err != nil {
+		return nil, false, err
+	}
+
+	migrations := dataMigrations[kind]
+
+	for v := version; v < len(migrations); v++ {
+		data, err = migrations[v](data)
+		if err != nil {
+			return nil, false, errors.New("could not migrate " + kind + " data from version " + strconv.Itoa(v) + ": " + err.Error())
+		}
+	}
+	return data, version < len(migrations), nil
+}
+
+// encodeVersionedData encodes v and wraps it in envelope with current version of kind.
+func encodeVersionedData(kind string, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(dataEnvelope{Version: currentDataVersion(kind), Data: data})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Files written by bot before versioning, in format of json.Marshal of its data types.
+const (
+	legacyFlashcards = `{"123456":{"biologia":{"mitochondrium":"centrum energetyczne komórki"}},"-1001234567890":{"chemia":{"sód":"Na"}}}`
+	legacyReminders  = `{"123456":[{"Date":"2021-06-21T10:00:00Z","Title":"kolokwium z analizy"}]}`
+	legacySchedules  = `{"123456":{"1":[{"Starts":"0000-01-01T08:00:00Z","Ends":"0000-01-01T09:30:00Z","Name":"Analiza"}]}}`
+)
+
+func TestUnwrapData(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		version int
+		data    string
+		wantErr bool
+	}{
+		{"legacy flashcards", legacyFlashcards, 0, legacyFlashcards, false},
+		{"legacy reminders", legacyReminders, 0, legacyReminders, false},
+		{"legacy schedules", legacySchedules, 0, legacySchedules, false},
+		{"legacy empty file", `{}`, 0, `{}`, false},
+		{"envelope", `{"version":1,"data":` + legacyReminders + `}`, 1, legacyReminders, false},
+		{"envelope with list", `{"version":0,"data":[]}`, 0, `[]`, false},
+		{"version without data", `{"version":1}`, 0, "", true},
+		{"bad version", `{"version":"1","data":{}}`, 0, "", true},
+		{"not json", `{"123456":`, 0, "", true},
+		{"not object", `[]`, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, data, err := unwrapData([]byte(tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.version {
+				t.Errorf("version = %d, want %d", version, tt.version)
+			}
+			if string(data) != tt.data {
+				t.Errorf("data = %s, want %s", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestDecodeVersionedData(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		content  string
+		data     interface{}
+		want     interface{}
+		migrated bool
+		wantErr  bool
+	}{
+		{
+			name:    "legacy flashcards",
+			kind:    flashcardsKind,
+			content: legacyFlashcards,
+			data:    &flashcardsData{},
+			want: &flashcardsData{
+				123456:         {"biologia": {"mitochondrium": "centrum energetyczne komórki"}},
+				-1001234567890: {"chemia": {"sód": "Na"}},
+			},
+			migrated: true,
+		},
+		{
+			name:     "legacy reminders",
+			kind:     remindersKind,
+			content:  legacyReminders,
+			data:     &remindersData{},
+			want:     &remindersData{123456: {{Date: time.Date(2021, 6, 21, 10, 0, 0, 0, time.UTC), Title: "kolokwium z analizy"}}},
+			migrated: true,
+		},
+		{
+			name:    "legacy schedules",
+			kind:    schedulesKind,
+			content: legacySchedules,
+			data:    &schedulesData{},
+			want: &schedulesData{123456: {monday: {{
+				Starts: time.Date(0, 1, 1, 8, 0, 0, 0, time.UTC),
+				Ends:   time.Date(0, 1, 1, 9, 30, 0, 0, time.UTC),
+				Name:   "Analiza",
+			}}}},
+			migrated: true,
+		},
+		{
+			name:    "current flashcards",
+			kind:    flashcardsKind,
+			content: `{"version":1,"data":{"1":{"a":{"b":"c"}}}}`,
+			data:    &flashcardsData{},
+			want:    &flashcardsData{1: {"a": {"b": "c"}}},
+		},
+		{
+			name:    "current outbox",
+			kind:    outboxKind,
+			content: `{"version":0,"data":[{"ID":"1-1","ChatID":1,"Text":"hej"}]}`,
+			data:    &[]pendingMessage{},
+			want:    &[]pendingMessage{{ID: "1-1", ChatID: 1, Text: "hej"}},
+		},
+		{
+			name:    "current languages",
+			kind:    languagesKind,
+			content: `{"version":0,"data":{"1":"en"}}`,
+			data:    &languagesData{},
+			want:    &languagesData{1: english},
+		},
+		{name: "newer flashcards", kind: flashcardsKind, content: `{"version":2,"data":{}}`, wantErr: true},
+		{name: "newer outbox", kind: outboxKind, content: `{"version":1,"data":[]}`, wantErr: true},
+		{name: "newer languages", kind: languagesKind, content: `{"version":1,"data":{}}`, wantErr: true},
+		{name: "broken", kind: remindersKind, content: `{"version":1,"data":`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, migrated, err := decodeVersionedData(tt.kind, []byte(tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migrated != tt.migrated {
+				t.Errorf("migrated = %v, want %v", migrated, tt.migrated)
+			}
+			if err := json.Unmarshal(data, tt.data); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.data, tt.want) {
+				t.Errorf("data = %#v, want %#v", tt.data, tt.want)
+			}
+		})
+	}
+}
+
+// TestDataMigrationSteps runs every registered step on fixture of version it upgrades from. Fixture has to be added for every new step.
+func TestDataMigrationSteps(t *testing.T) {
+	fixtures := map[string][]struct {
+		from string
+		want string
+	}{
+		flashcardsKind: {{legacyFlashcards, legacyFlashcards}},
+		remindersKind:  {{legacyReminders, legacyReminders}},
+		schedulesKind:  {{legacySchedules, legacySchedules}},
+	}
+
+	for kind, migrations := range dataMigrations {
+		if len(fixtures[kind]) != len(migrations) {
+			t.Errorf("%s has %d migrations, but %d fixtures", kind, len(migrations), len(fixtures[kind]))
+			continue
+		}
+		for v, migrate := range migrations {
+			f := fixtures[kind][v]
+			data, err := migrate(json.RawMessage(f.from))
+			if err != nil {
+				t.Errorf("%s from version %d: %v", kind, v, err)
+				continue
+			}
+			if string(data) != f.want {
+				t.Errorf("%s from version %d = %s, want %s", kind, v, data, f.want)
+			}
+		}
+	}
+}
+
+func TestEncodeVersionedData(t *testing.T) {
+	for kind := range dataMigrations {
+		content, err := encodeVersionedData(kind, map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, migrated, err := decodeVersionedData(kind, content)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if migrated {
+			t.Errorf("%s: data written in current version was migrated", kind)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultDataBackups is how many previous versions of every data file are kept.
+const defaultDataBackups = 3
+
+// backupFileName returns name of n-th backup of file, 1 is the newest one.
+func backupFileName(fileName string, n int) string {
+	return fileName + ".bak." + strconv.Itoa(n)
+}
+
+// syncDir flushes directory entry changes, e.g. rename, to disk. Some systems can't sync directories, so error is only logged.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	if err = d.Sync(); err != nil {
+		log.WithFields(log.Fields{
+			"dir": dir,
+		}).Debug("Could not sync directory")
+	}
+}
+
+// rotateBackups moves every backup of file one place further, deleting the oldest one, and makes current file the newest backup.
+func rotateBackups(fileName string, backups int) error {
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	err := os.Remove(backupFileName(fileName, backups))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := backups - 1; n > 0; n-- {
+		err = os.Rename(backupFileName(fileName, n), backupFileName(fileName, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// hard link keeps current file in place, so there is no moment without it
+	if err = os.Link(fileName, backupFileName(fileName, 1)); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(backupFileName(fileName, 1), data, 0600)
+}
+
+// writeDataFile replaces file with data atomically. Data is written to temporary file in the same directory, synced to disk and renamed over old file, so after crash file has either old or new content. Old content is kept in rotating backups.
+func writeDataFile(fileName string, data []byte, backups int) error {
+	dir := filepath.Dir(fileName)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(fileName)+".tmp-")
+	if err != nil {
+		return err
+	}
+	// after successful rename temp file doesn't exist anymore, so this only cleans up after errors
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	// data files keep personal data, so only bot's user can read them
+	if err = os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+
+	if backups > 0 {
+		if err = rotateBackups(fileName, backups); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Rename(tmp.Name(), fileName); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// readDataFile reads file and passes its content to decode. If file is missing or decode fails, it tries backups from the newest one and restores the first valid one. Broken file is kept next to it with .broken suffix. If there is no file and no backups, empty content is used, so bot can start for the first time.
+func readDataFile(fileName string, backups int, empty []byte, decode func([]byte) error) error {
+	ioLogger := generateIoLogger(fileName, "readDataFile")
+
+	data, err := ioutil.ReadFile(fileName)
+	missing := os.IsNotExist(err)
+	if err == nil {
+		if err = decode(data); err == nil {
+			return nil
+		}
+		ioLogger.Error("Could not decode file: " + err.Error())
+	} else if !missing {
+		ioLogger.Error("Could not read file: " + err.Error())
+	}
+
+	for n := 1; n <= backups; n++ {
+		backup := backupFileName(fileName, n)
+		data, err := ioutil.ReadFile(backup)
+		if err != nil || decode(data) != nil {
+			continue
+		}
+
+		ioLogger.WithFields(log.Fields{
+			"backup": backup,
+		}).Warn("!!! DATA FILE IS MISSING OR BROKEN, LOADED BACKUP. CHANGES MADE AFTER THE BACKUP ARE LOST !!!")
+
+		if !missing {
+			broken := fileName + ".broken-" + time.Now().Format("20060102-150405")
+			if err := os.Rename(fileName, broken); err != nil {
+				ioLogger.Error("Could not move broken file aside")
+			}
+		}
+		if err := writeDataFile(fileName, data, 0); err != nil {
+			ioLogger.Error("Could not restore file from backup")
+		}
+		return nil
+	}
+
+	if !missing {
+		return errors.New("data file " + fileName + " is broken and there is no valid backup")
+	}
+
+	if err := writeDataFile(fileName, empty, 0); err != nil {
+		ioLogger.Error("Could not create file")
+		return err
+	}
+	return decode(empty)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// answerError is returned by step parser when answer can't be accepted. Its message is sent to user.
+// If retry is true, the same question is asked again, otherwise whole dialog ends.
+type answerError struct {
+	msg   string
+	retry bool
+}
+
+func (e *answerError) Error() string {
+	return e.msg
+}
+
+// invalidAnswer creates error after which step is repeated, if its retry policy allows it.
+func invalidAnswer(msg string) error {
+	return &answerError{msg, true}
+}
+
+// stopDialog creates error which ends dialog, e.g. when user wants to edit flashcard that doesn't exist.
+func stopDialog(msg string) error {
+	return &answerError{msg, false}
+}
+
+// dialogAnswers stores parsed answers from previous steps by step name.
+type dialogAnswers map[string]interface{}
+
+// String returns answer of given step as a string.
+func (da dialogAnswers) String(name string) string {
+	s, _ := da[name].(string)
+	return s
+}
+
+// Int returns answer of given step as an int.
+func (da dialogAnswers) Int(name string) int {
+	i, _ := da[name].(int)
+	return i
+}
+
+// Time returns answer of given step as a time.
+func (da dialogAnswers) Time(name string) time.Time {
+	t, _ := da[name].(time.Time)
+	return t
+}
+
+// Topic returns answer of given step as a topic.
+func (da dialogAnswers) Topic(name string) topic {
+	t, _ := da[name].(topic)
+	return t
+}
+
+// Weekday returns answer of given step as a weekday.
+func (da dialogAnswers) Weekday(name string) Weekday {
+	w, _ := da[name].(Weekday)
+	return w
+}
+
+// Language returns answer of given step as a language.
+func (da dialogAnswers) Language(name string) language {
+	l, _ := da[name].(language)
+	return l
+}
+
+const (
+	// backCommand repeats previous question of dialog.
+	backCommand = "/wstecz"
+	// cancelCommand ends dialog.
+	cancelCommand = "/anuluj"
+	// maxDialogRetries is default number of invalid answers after which dialog ends.
+	maxDialogRetries = 3
+)
+
+var errTooManyRetries = errors.New("too many invalid answers")
+
+// dialogStep is a single question of dialog.
+// Name is a key under which parsed answer is stored in dialogAnswers.
+// Prompt returns question, it gets answers from previous steps, so question can depend on them.
+// Options optionally returns suggested answers shown as keyboard.
+// Parse converts and validates answer. Without parser answer is stored as it is.
+// Retries is how many times step is repeated after invalid answer, zero means maxDialogRetries.
+type dialogStep struct {
+	Name    string
+	Prompt  func(dialogAnswers) string
+	Options func(dialogAnswers) []string
+	Parse   func(answer string, answers dialogAnswers) (interface{}, error)
+	Retries int
+}
+
+// prompt returns Prompt function which always asks the same question.
+func prompt(question string) func(dialogAnswers) string {
+	return func(dialogAnswers) string {
+		return question
+	}
+}
+
+// maxRetries returns how many times step can be repeated after invalid answer.
+func (ds dialogStep) maxRetries() int {
+	if ds.Retries == 0 {
+		return maxDialogRetries
+	}
+	return ds.Retries
+}
+
+// RunDialog asks user questions from given steps one by one and returns parsed answers. Answers longer than bot's text limit are invalid. Invalid answer is asked again until step's retry limit is reached and backCommand goes back to previous question. If dialog ends before last step, it returns error and answers should be discarded.
+func (b *Bot) RunDialog(ctx context.Context, chatID chatid, steps []dialogStep) (dialogAnswers, error) {
+	chatLogger := generateDialogLogger(chatID)
+	answers := dialogAnswers{}
+
+	attempts := 0
+	for i := 0; i < len(steps); {
+		step := steps[i]
+
+		var options []string
+		if step.Options != nil {
+			options = step.Options(answers)
+		}
+
+		a, err := b.DialogWithOptions(ctx, chatID, step.Prompt(answers), options)
+		if err != nil {
+			chatLogger.Info("Dialog ended unsuccessfully")
+			return nil, err
+		}
+
+		if a == backCommand {
+			if i > 0 {
+				i--
+			}
+			attempts = 0
+			continue
+		}
+
+		var v interface{} = a
+		if b.Limits.MaxTextLength > 0 && textLength(a) > b.Limits.MaxTextLength {
+			err = invalidAnswer(b.T(chatID, "dialog.tooLong", b.Limits.MaxTextLength))
+		} else if step.Parse != nil {
+			v, err = step.Parse(a, answers)
+		}
+		if err == nil {
+			answers[step.Name] = v
+			i++
+			attempts = 0
+			continue
+		}
+
+		ae, ok := err.(*answerError)
+		if !ok {
+			chatLogger.Error("Could not parse answer: " + err.Error())
+			return nil, err
+		}
+
+		b.Output <- Msg{chatID: chatID, text: ae.msg}
+		if !ae.retry {
+			chatLogger.Info("Dialog ended unsuccessfully")
+			return nil, err
+		}
+
+		attempts++
+		if attempts > step.maxRetries() {
+			chatLogger.Info("Dialog ended after too many invalid answers")
+			b.Output <- Msg{chatID: chatID, text: b.T(chatID, "dialog.tooManyRetries")}
+			return nil, errTooManyRetries
+		}
+	}
+
+	return answers, nil
+}
+
+// Cancel ends dialog opened in chat on user's request.
+func (b *Bot) Cancel(chatID chatid) {
+	if b.Sessions.Cancel(chatID) {
+		b.Output <- Msg{chatID: chatID, text: b.T(chatID, "dialog.cancelled")}
+		return
+	}
+	b.Output <- Msg{chatID: chatID, text: b.T(chatID, "dialog.nothingToCancel")}
+}
+
+// Back passes backCommand to dialog opened in chat, so it asks previous question again.
+func (b *Bot) Back(chatID chatid) {
+	if !b.Sessions.Deliver(chatID, backCommand) {
+		b.Output <- Msg{chatID: chatID, text: b.T(chatID, "dialog.noDialog")}
+	}
+}
+
+// confirmStep asks user to confirm that data should be deleted by writing confirmation word. Any other answer ends dialog with refusal message.
+func confirmStep(lang language, question string, refusal string) dialogStep {
+	return dialogStep{
+		Name:   "confirmation",
+		Prompt: prompt(question),
+		Parse: func(answer string, _ dialogAnswers) (interface{}, error) {
+			if answer != lang.T("dialog.confirmWord") {
+				return nil, stopDialog(refusal)
+			}
+			return answer, nil
+		},
+	}
+}
+
+// topicStep asks for flashcards topic.
+func topicStep(lang language) dialogStep {
+	return dialogStep{
+		Name:   "topic",
+		Prompt: prompt(lang.T("topic.ask")),
+		Parse: func(answer string, _ dialogAnswers) (interface{}, error) {
+			return topic(strings.ToLower(answer)), nil
+		},
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sealedPrefix starts every encrypted value, it is followed by key ID, colon and base64 of nonce and ciphertext. Data without prefix is plaintext.
+const sealedPrefix = "sabenc1:"
+
+// encryptionConfig tells where encryption keys are. KeyFile has one key per line, Keys has keys separated by commas, both in format "id:base64 of 32 bytes". The first key encrypts new data, others only decrypt data encrypted before rotation. Keys are read only from env, so they don't end up in config file.
+type encryptionConfig struct {
+	KeyFile string `yaml:"keyFile"`
+	Keys    string `yaml:"-"`
+}
+
+// keyring keeps AES-GCM keys by their IDs. Primary is ID of key used for encryption, empty primary means data is only decrypted and written as plaintext.
+type keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// parseKeys adds keys written as "id:base64" to keyring, the first one becomes primary if keyring doesn't have one yet.
+func (k *keyring) parseKeys(lines []string) error {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return errors.New("key has to be in format id:base64")
+		}
+		id := parts[0]
+		if _, ok := k.aeads[id]; ok {
+			return errors.New("key " + id + " is given twice")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return errors.New("key " + id + " is not valid base64")
+		}
+		if len(key) != 32 {
+			return errors.New("key " + id + " has to have 32 bytes")
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		k.aeads[id] = aead
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+	return nil
+}
+
+// loadKeyring reads keys from config. Keys from env go before keys from file, so the primary key can be changed without editing file. It returns nil if no key is configured, then data is not encrypted.
+func loadKeyring(ec encryptionConfig) (*keyring, error) {
+	k := &keyring{aeads: make(map[string]cipher.AEAD)}
+	if ec.Keys != "" {
+		if err := k.parseKeys(strings.Split(ec.Keys, ",")); err != nil {
+			return nil, errors.New("encryptionKeys: " + err.Error())
+		}
+	}
+	if ec.KeyFile != "" {
+		info, err := os.Stat(ec.KeyFile)
+		if err != nil {
+			return nil, errors.New("could not read key file: " + err.Error())
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			log.WithField("file", ec.KeyFile).Warn("Key file can be read by other users, set its permissions to 0600")
+		}
+		content, err := ioutil.ReadFile(ec.KeyFile)
+		if err != nil {
+			return nil, errors.New("could not read key file: " + err.Error())
+		}
+		if err := k.parseKeys(strings.Split(string(content), "\n")); err != nil {
+			return nil, errors.New("key file " + ec.KeyFile + ": " + err.Error())
+		}
+	}
+	if len(k.aeads) == 0 {
+		return nil, nil
+	}
+	return k, nil
+}
+
+// decryptOnly returns keyring with the same keys which doesn't encrypt, it is used to decrypt stored data.
+func (k *keyring) decryptOnly() *keyring {
+	if k == nil {
+		return nil
+	}
+	return &keyring{aeads: k.aeads}
+}
+
+// isSealed reports if data is encrypted.
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedPrefix))
+}
+
+// seal encrypts data with primary key. Without keyring or primary key data is returned as it is.
+func (k *keyring) seal(data []byte) ([]byte, error) {
+	if k == nil || k.primary == "" {
+		return data, nil
+	}
+
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// key ID is authenticated, so ciphertext can't be moved under other key
+	ciphertext := aead.Seal(nonce, nonce, data, []byte(k.primary))
+
+	sealed := sealedPrefix + k.primary + ":" + base64.StdEncoding.EncodeToString(ciphertext)
+	return []byte(sealed), nil
+}
+
+// open decrypts data with key whose ID is written in it. Plaintext data is returned as it is, so data written before encryption was enabled can be read.
+func (k *keyring) open(data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+
+	parts := strings.SplitN(string(bytes.TrimSpace(data[len(sealedPrefix):])), ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("encrypted data is broken")
+	}
+	id := parts[0]
+	if k == nil {
+		return nil, errors.New("data is encrypted with key " + id + ", but no encryption key is configured")
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, errors.New("data is encrypted with key " + id + " which is not configured")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is broken")
+	}
+	data, err = aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, errors.New("could not decrypt data with key " + id + ", data or key is wrong")
+	}
+	return data, nil
+}
+
+// sealString encrypts text value, e.g. database column.
+func (k *keyring) sealString(s string) (string, error) {
+	sealed, err := k.seal([]byte(s))
+	return string(sealed), err
+}
+
+// openString decrypts text value, e.g. database column.
+func (k *keyring) openString(s string) (string, error) {
+	data, err := k.open([]byte(s))
+	return string(data), err
+}
+
+// recryptDataFile decrypts file with any key of keyring and writes it again encrypted with its primary key, or as plaintext if keyring only decrypts. Missing file is skipped.
+func recryptDataFile(fileName string, keys *keyring) error {
+	content, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := keys.open(content)
+	if err != nil {
+		return errors.New(fileName + ": " + err.Error())
+	}
+	if data, err = keys.seal(data); err != nil {
+		return err
+	}
+	return writeDataFile(fileName, data, 0)
+}
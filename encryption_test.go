@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey returns key line with key made of repeated byte.
+func testKey(id string, b byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// newTestKeyring creates keyring from key lines, the first one is primary.
+func newTestKeyring(t *testing.T, lines ...string) *keyring {
+	k := &keyring{aeads: make(map[string]cipher.AEAD)}
+	if err := k.parseKeys(lines); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		primary string
+		keys    int
+		wantErr bool
+	}{
+		{name: "one key", lines: []string{testKey("a", 1)}, primary: "a", keys: 1},
+		{name: "first key is primary", lines: []string{testKey("b", 2), testKey("a", 1)}, primary: "b", keys: 2},
+		{name: "comments and blank lines", lines: []string{"# old keys below", "", "  " + testKey("a", 1) + "  "}, primary: "a", keys: 1},
+		{name: "no id", lines: []string{":" + base64.StdEncoding.EncodeToString(make([]byte, 32))}, wantErr: true},
+		{name: "no colon", lines: []string{"abc"}, wantErr: true},
+		{name: "twice", lines: []string{testKey("a", 1), testKey("a", 2)}, wantErr: true},
+		{name: "not base64", lines: []string{"a:???"}, wantErr: true},
+		{name: "short key", lines: []string{"a:" + base64.StdEncoding.EncodeToString(make([]byte, 16))}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &keyring{aeads: make(map[string]cipher.AEAD)}
+			err := k.parseKeys(tt.lines)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.primary != tt.primary || len(k.aeads) != tt.keys {
+				t.Errorf("primary %q with %d keys, want %q with %d", k.primary, len(k.aeads), tt.primary, tt.keys)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old := newTestKeyring(t, testKey("a", 1))
+	rotated := newTestKeyring(t, testKey("b", 2), testKey("a", 1))
+	onlyNew := newTestKeyring(t, testKey("b", 2))
+	wrongKey := newTestKeyring(t, testKey("a", 3))
+
+	plaintext := []byte(`{"version":1,"data":{}}`)
+	sealed, err := old.seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(sealed), sealedPrefix+"a:") || bytes.Contains(sealed, plaintext) {
+		t.Fatalf("data is not sealed with key a: %s", sealed)
+	}
+
+	resealed, err := rotated.seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(resealed), sealedPrefix+"b:") {
+		t.Fatalf("data is not sealed with new primary key: %s", resealed)
+	}
+
+	tests := []struct {
+		name    string
+		keys    *keyring
+		data    []byte
+		wantErr bool
+	}{
+		{"old key", old, sealed, false},
+		{"old key after rotation", rotated, sealed, false},
+		{"new key after rotation", rotated, resealed, false},
+		{"old key removed", onlyNew, sealed, true},
+		{"new key unknown", old, resealed, true},
+		{"wrong key with the same id", wrongKey, sealed, true},
+		{"decrypt only", rotated.decryptOnly(), resealed, false},
+		{"no keys", nil, sealed, true},
+		{"plaintext", nil, plaintext, false},
+		{"plaintext with keys", rotated, plaintext, false},
+		{"broken", rotated, []byte(sealedPrefix + "b:!!!"), true},
+		{"moved to other key", rotated, []byte(strings.Replace(string(sealed), ":a:", ":b:", 1)), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.keys.open(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, plaintext) {
+				t.Errorf("opened %s, want %s", data, plaintext)
+			}
+		})
+	}
+}
+
+func TestKeyringWithoutPrimary(t *testing.T) {
+	for _, k := range []*keyring{nil, newTestKeyring(t, testKey("a", 1)).decryptOnly()} {
+		data, err := k.seal([]byte("kot"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "kot" {
+			t.Errorf("keyring without primary key sealed data: %s", data)
+		}
+	}
+}
+
+func TestRecryptDataFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), flashcardsFileName)
+	plaintext := []byte(`{"version":1,"data":{}}`)
+	sealed, err := newTestKeyring(t, testKey("a", 1)).seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fileName, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := newTestKeyring(t, testKey("b", 2), testKey("a", 1))
+	steps := []struct {
+		name   string
+		keys   *keyring
+		prefix string
+	}{
+		{"rotate", rotated, sealedPrefix + "b:"},
+		{"decrypt", rotated.decryptOnly(), `{"version"`},
+		{"encrypt", newTestKeyring(t, testKey("b", 2)), sealedPrefix + "b:"},
+	}
+	for _, s := range steps {
+		if err := recryptDataFile(fileName, s.keys); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		content, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(content), s.prefix) {
+			t.Fatalf("%s: file starts with %.20q, want %q", s.name, content, s.prefix)
+		}
+		if data, err := s.keys.open(content); err != nil || !bytes.Equal(data, plaintext) {
+			t.Fatalf("%s: could not read file back: %v", s.name, err)
+		}
+	}
+
+	if err := recryptDataFile(filepath.Join(t.TempDir(), "missing.json"), rotated); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lowestGrade is given when result is below every threshold of grade scale.
+const lowestGrade = 2.0
+
+// maxExamMinutes limits exam duration and time for single question, so big numbers can't overflow duration.
+const maxExamMinutes = 24 * 60
+
+var errExamTooLong = errors.New("exam is too long")
+
+// gradeThreshold maps minimal percent of correct answers to a grade.
+type gradeThreshold struct {
+	MinPercent float64
+	Grade      float64
+}
+
+// gradeScale is a list of thresholds used for grading exams, sorted from the highest one.
+type gradeScale []gradeThreshold
+
+// examSettings stores arguments given to /egzamin.
+// Duration is time limit for whole exam.
+// QuestionLimit is optional time limit for single question, zero means no limit.
+type examSettings struct {
+	Topic         topic
+	Questions     int
+	Duration      time.Duration
+	QuestionLimit time.Duration
+}
+
+// defaultGradeScale returns grade scale commonly used on polish universities.
+func defaultGradeScale() gradeScale {
+	return gradeScale{
+		{91, 5.0},
+		{81, 4.5},
+		{71, 4.0},
+		{61, 3.5},
+		{51, 3.0},
+	}
+}
+
+// parseGradeScale parses grade scale written as comma separated percent:grade pairs, e.g. "51:3.0,61:3.5,71:4.0,81:4.5,91:5.0".
+func parseGradeScale(s string) (gradeScale, error) {
+	gs := gradeScale{}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), ":")
+		if len(parts) != 2 {
+			return nil, errors.New("grade scale entry must look like percent:grade")
+		}
+
+		percent, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, errors.New("grade scale percent must be a number between 0 and 100")
+		}
+
+		grade, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || grade < lowestGrade || grade > 5.0 {
+			return nil, errors.New("grade must be a number between 2.0 and 5.0")
+		}
+
+		gs = append(gs, gradeThreshold{percent, grade})
+	}
+
+	sort.Slice(gs, func(i, j int) bool { return gs[i].MinPercent > gs[j].MinPercent })
+	return gs, nil
+}
+
+// examPercent returns percent of correct answers rounded down to one decimal place. Grade is given for the same value which is shown to user, so they always match.
+func examPercent(correct int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Floor(float64(correct)*1000/float64(total)) / 10
+}
+
+// Grade returns grade for given number of correct answers.
+func (gs gradeScale) Grade(correct int, total int) float64 {
+	if total == 0 {
+		return lowestGrade
+	}
+
+	percent := examPercent(correct, total)
+	for _, t := range gs {
+		if percent >= t.MinPercent {
+			return t.Grade
+		}
+	}
+	return lowestGrade
+}
+
+// parseExamSettings parses /egzamin payload. Trailing numbers are settings and everything before them is a topic, so topic can contain spaces.
+func parseExamSettings(payload string) (examSettings, error) {
+	var es examSettings
+	fields := strings.Fields(strings.ToLower(payload))
+
+	numbers := []int{}
+	i := len(fields)
+	for i > 0 && len(numbers) < 3 {
+		n, err := strconv.Atoi(fields[i-1])
+		if err != nil {
+			break
+		}
+		numbers = append([]int{n}, numbers...)
+		i--
+	}
+
+	if i == 0 || len(numbers) < 2 {
+		return es, errors.New("wrong exam arguments")
+	}
+
+	for _, n := range numbers {
+		if n <= 0 {
+			return es, errors.New("exam arguments must be positive")
+		}
+	}
+	if numbers[1] > maxExamMinutes || (len(numbers) == 3 && numbers[2] > maxExamMinutes*60) {
+		return es, errExamTooLong
+	}
+
+	es.Topic = topic(strings.Join(fields[:i], " "))
+	es.Questions = numbers[0]
+	es.Duration = time.Duration(numbers[1]) * time.Minute
+	if len(numbers) == 3 {
+		es.QuestionLimit = time.Duration(numbers[2]) * time.Second
+	}
+
+	return es, nil
+}
+
+// drawExamTerms returns n random terms from given flashcards or all of them if there is not enough.
+func drawExamTerms(fc flashcards, n int) []string {
+	terms := make([]string, 0, len(fc))
+	for term := range fc {
+		terms = append(terms, term)
+	}
+
+	rand.Shuffle(len(terms), func(i, j int) { terms[i], terms[j] = terms[j], terms[i] })
+
+	if n < len(terms) {
+		terms = terms[:n]
+	}
+	return terms
+}
+
+// formatRemaining formats duration as minutes and seconds.
+func formatRemaining(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// Exam starts exam from flashcards of given topic. Whole exam has to be finished before deadline and every question can have its own time limit. When time runs out, unanswered questions are treated as wrong and bot sends graded result.
+func (b *Bot) Exam(ctx context.Context, chatID chatid, payload string) {
+	chatLogger := generateDialogLogger(chatID)
+	lang := b.Language(chatID)
+
+	es, err := parseExamSettings(payload)
+	if err == errExamTooLong {
+		b.Output <- Msg{chatID: chatID, text: lang.T("exam.tooLong", maxExamMinutes)}
+		return
+	}
+	if err != nil {
+		b.Output <- Msg{chatID: chatID, text: lang.T("exam.usage")}
+		return
+	}
+
+	topics, err := b.Store.Flashcards(chatID)
+	if err != nil {
+		chatLogger.Error("Could not load flashcards")
+		return
+	}
+
+	fcTopic, ok := topics[es.Topic]
+	if !ok || len(fcTopic) == 0 {
+		b.Output <- Msg{chatID: chatID, text: lang.T("topic.notFound")}
+		return
+	}
+
+	terms := drawExamTerms(fcTopic, es.Questions)
+	deadline := time.Now().Add(es.Duration)
+
+	startMessage := lang.T("exam.start", strings.Title(string(es.Topic)), len(terms), formatRemaining(es.Duration))
+	if es.QuestionLimit > 0 {
+		startMessage += lang.T("exam.questionLimit", int(es.QuestionLimit.Seconds()))
+	}
+	b.Output <- Msg{chatID: chatID, text: startMessage}
+
+	// answers are graded at the end, so answer to question repeated after backCommand replaces the previous one
+	given := make([]string, len(terms))
+	answered := make([]bool, len(terms))
+	for i := 0; i < len(terms); {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+
+		timeout := remaining
+		if es.QuestionLimit > 0 && es.QuestionLimit < timeout {
+			timeout = es.QuestionLimit
+		}
+
+		question := lang.T("exam.question", i+1, len(terms), formatRemaining(remaining), fcTopic[terms[i]])
+		b.Output <- Msg{chatID: chatID, text: question}
+
+		answer, err := getAnswer(ctx, timeout)
+		if err == errDialogEnded {
+			chatLogger.Info("Dialog ended unsuccessfully")
+			return
+		}
+		if err == errTimeout {
+			if time.Now().Before(deadline) {
+				b.Output <- Msg{chatID: chatID, text: lang.T("exam.questionTimeout")}
+			}
+			i++
+			continue
+		}
+
+		// like in other dialogs, backCommand asks previous question again
+		if answer == backCommand {
+			if i > 0 {
+				i--
+			}
+			continue
+		}
+
+		given[i] = answer
+		answered[i] = true
+		i++
+	}
+
+	correct, unanswered := 0, 0
+	for i, term := range terms {
+		if !answered[i] {
+			unanswered++
+		} else if strings.ToLower(given[i]) == term {
+			correct++
+		}
+	}
+
+	if !time.Now().Before(deadline) {
+		b.Output <- Msg{chatID: chatID, text: lang.T("exam.timeUp")}
+	}
+
+	percent := strconv.FormatFloat(examPercent(correct, len(terms)), 'f', -1, 64)
+	grade := b.GradeScale.Grade(correct, len(terms))
+	result := lang.T("exam.result", correct, len(terms), percent, unanswered, strconv.FormatFloat(grade, 'f', 1, 64))
+
+	b.Output <- Msg{chatID: chatID, text: result}
+}
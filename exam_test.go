@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestExamGrade(t *testing.T) {
+	gs := gradeScale{{66.7, 4.0}, {50, 3.0}}
+	tests := []struct {
+		correct int
+		total   int
+		percent float64
+		grade   float64
+	}{
+		{2, 3, 66.6, 3.0},
+		{667, 1000, 66.7, 4.0},
+		{1, 2, 50, 3.0},
+		{499, 1000, 49.9, lowestGrade},
+		{3, 3, 100, 4.0},
+		{0, 0, 0, lowestGrade},
+	}
+
+	for _, tt := range tests {
+		if got := examPercent(tt.correct, tt.total); got != tt.percent {
+			t.Errorf("examPercent(%d, %d) = %v, want %v", tt.correct, tt.total, got, tt.percent)
+		}
+		if got := gs.Grade(tt.correct, tt.total); got != tt.grade {
+			t.Errorf("Grade(%d, %d) = %v, want %v", tt.correct, tt.total, got, tt.grade)
+		}
+	}
+}
@@ -2,12 +2,10 @@ package main
 
 import (
 	//"bytes"
-	"encoding/json"
-	"io/ioutil"
+	"context"
+	"html"
+	"sort"
 	"strings"
-
-	log "github.com/sirupsen/logrus"
-	tba "gopkg.in/tucnak/telebot.v2" //telegram bot api
 )
 
 const flashcardsFileName = "flashcards.json"
@@ -16,183 +14,195 @@ type topic string
 type flashcards map[string]string
 type flashcardsData map[chatid]map[topic]flashcards
 
-// writeFlashcards rewrites flashcards in .json file. If file doesn't exists it will create a new one.
-func writeFlashcards(fc flashcardsData, ioLogger *log.Entry) error {
-	fcJSON, err := json.Marshal(fc)
-	if err != nil {
-		ioLogger.Error("Could not encode flashcards")
-		return err
+// termStep asks for term of flashcard in topic given in previous step. If mustExist is true, flashcard has to exist, otherwise it can't exist.
+func (b *Bot) termStep(chatID chatid, lang language, mustExist bool) dialogStep {
+	return dialogStep{
+		Name:   "term",
+		Prompt: prompt(lang.T("flashcard.askTerm")),
+		Parse: func(answer string, answers dialogAnswers) (interface{}, error) {
+			term := strings.ToLower(answer)
+			_, ok, err := b.Store.Flashcard(chatID, answers.Topic("topic"), term)
+			if err != nil {
+				return nil, err
+			}
+			if mustExist && !ok {
+				return nil, invalidAnswer(lang.T("flashcard.notFound"))
+			}
+			if !mustExist && ok {
+				return nil, invalidAnswer(lang.T("flashcard.exists"))
+			}
+			return term, nil
+		},
 	}
+}
 
-	err = ioutil.WriteFile(flashcardsFileName, fcJSON, 0644)
-	if err != nil {
-		ioLogger.Error("Could not write file")
-		return err
+// countFlashcards returns number of flashcards in all topics.
+func countFlashcards(topics map[topic]flashcards) int {
+	n := 0
+	for _, fc := range topics {
+		n += len(fc)
 	}
-
-	return err
+	return n
 }
 
-// AddFlashcard launch dialog for creating a new flashcard. It checks if flashcard exists and if not it will add flashcards to FlashcardsData and save it in a file.
-func (b *Bot) AddFlashcard(chatID chatid) {
-	chatLogger := generateDialogLogger(chatID)
-	ioLogger := generateIoLogger(flashcardsFileName, "addFlashcard")
-	fc := b.FlashcardsData
-	defer func() { b.InactiveInput <- chatID }()
-
-	t, err := b.Dialog(chatID, "Podaj temat")
-	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
+// AddFlashcard launch dialog for creating a new flashcard. It checks if flashcard exists and if not it will add flashcard to store. Chat can't have more flashcards than bot's limit.
+func (b *Bot) AddFlashcard(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	if b.Limits.MaxFlashcards > 0 {
+		topics, err := b.Store.Flashcards(chatID)
+		if err != nil {
+			generateDialogLogger(chatID).Error("Could not load flashcards")
+			b.Output <- Msg{chatID: chatID, text: lang.T("error.tryLater")}
+			return
+		}
+		if countFlashcards(topics) >= b.Limits.MaxFlashcards {
+			b.Output <- Msg{chatID: chatID, text: lang.T("flashcard.limit", b.Limits.MaxFlashcards)}
+			return
+		}
 	}
-	t = strings.ToLower(t)
-	top := topic(t)
-
-	term, err := b.Dialog(chatID, "Podaj pojecie")
+	answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+		topicStep(lang),
+		b.termStep(chatID, lang, false),
+		{Name: "definition", Prompt: prompt(lang.T("flashcard.askDefinition"))},
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	term = strings.ToLower(term)
-	if _, ok := fc[chatID][top][term]; ok {
-		b.Output <- Msg{chatID, "Fiszka juz istnieje, edytuj za pomoca /edytujfiszke"}
 		return
 	}
 
-	definition, err := b.Dialog(chatID, "Podaj definicje")
+	err = b.Store.PutFlashcard(chatID, answers.Topic("topic"), answers.String("term"), answers.String("definition"))
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.saveFlashcard")}
 		return
 	}
 
-	if fc[chatID] == nil {
-		fc[chatID] = make(map[topic]flashcards)
-	}
+	b.Output <- Msg{chatID: chatID, text: lang.T("flashcard.added")}
+}
 
-	if fc[chatID][top] == nil {
-		fc[chatID][top] = make(flashcards)
+// DisplayFlashcard searches chat's flashcards for given term and sends defintion to user if finds it.
+func (b *Bot) DisplayFlashcard(chatID chatid, term string) {
+	lang := b.Language(chatID)
+	if term == "" {
+		b.Output <- Msg{chatID: chatID, text: lang.T("flashcard.termMissing")}
+		return
 	}
 
-	fc[chatID][top][term] = definition
-
-	err = writeFlashcards(fc, ioLogger)
+	topics, err := b.Store.Flashcards(chatID)
 	if err != nil {
-		b.Output <- Msg{chatID, "Wystapil problem, moga wystapic problemy z tym terminem w przyszlosci, skontaktuj sie z administratorem"}
-	}
-
-	b.FlashcardsData[chatID] = fc[chatID]
-	b.Output <- Msg{chatID, "Dodano fiszke"}
-}
-
-// DisplayFlashcard searches FlashcardsData for given term and sends defintion to user if finds it.
-func (b *Bot) DisplayFlashcard(m *tba.Message) {
-	chatID := chatid(m.Chat.ID)
-	fc := b.FlashcardsData
-
-	if m.Text == "/fiszka" {
-		b.Output <- Msg{chatID, "Podaj pojecie po spacji"}
+		generateDialogLogger(chatID).Error("Could not load flashcards")
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.tryLater")}
 		return
 	}
 
-	term := strings.ReplaceAll(m.Text, "/fiszka ", "")
 	answer := ""
 
-	for top, val := range fc[chatID] {
+	for top, val := range topics {
 		if definition, ok := val[strings.ToLower(term)]; ok {
-			answer = answer + "\n" + strings.Title(string(top)) + ", " + strings.Title(term) + " - " + definition
+			answer = answer + "\n" + bold(strings.Title(string(top))+", "+strings.Title(term)) + " - " + html.EscapeString(definition)
 		}
 	}
 
 	if answer != "" {
 		b.Output <- Msg{
-			chatID,
-			answer,
+			chatID: chatID,
+			text:   answer,
+			format: formatHTML,
 		}
 		return
 	}
 
-	b.Output <- Msg{chatID, "Nie znaleziono pojecia"}
+	b.Output <- Msg{chatID: chatID, text: lang.T("flashcard.termNotFound")}
 }
 
-// DeleteFlashcard starts dialog with user to check if given flashcard exists. If it exists, it will be deleted from FlashcardData.
-func (b *Bot) DeleteFlashcard(chatID chatid) {
-	chatLogger := generateDialogLogger(chatID)
-	ioLogger := generateIoLogger(flashcardsFileName, "deleteFlashcard")
-	defer func() { b.InactiveInput <- chatID }()
-	fc := b.FlashcardsData
-
-	t, err := b.Dialog(chatID, "Podaj temat")
+// DeleteFlashcard starts dialog with user to check if given flashcard exists. If it exists, it will be deleted from store.
+func (b *Bot) DeleteFlashcard(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+		topicStep(lang),
+		b.termStep(chatID, lang, true),
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
 		return
 	}
-	t = strings.ToLower(t)
-	top := topic(t)
 
-	term, err := b.Dialog(chatID, "Podaj pojecie")
+	err = b.Store.DeleteFlashcard(chatID, answers.Topic("topic"), answers.String("term"))
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	term = strings.ToLower(term)
-	if _, ok := fc[chatID][top][term]; !ok {
-
-		b.Output <- Msg{chatID, "Fiszka nie istnieje"}
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.saveFlashcard")}
 		return
 	}
 
-	delete(fc[chatID][top], term)
-
-	if fc[chatID][top] == nil {
-		delete(fc[chatID], top)
-	}
-	err = writeFlashcards(fc, ioLogger)
-	if err != nil {
-		b.Output <- Msg{chatID, "Wystapil problem, moga wystapic problemy z tym terminem w przyszlosci, skontaktuj sie z administratorem"}
-	}
-
-	b.FlashcardsData[chatID] = fc[chatID]
-	b.Output <- Msg{chatID, "Usunieto fiszke"}
+	b.Output <- Msg{chatID: chatID, text: lang.T("flashcard.deleted")}
 }
 
-// EditFlashcard starts dialog with user to check if given flashcard exists. If it exists, it's definition is edited and saved in FlashcardsData.
-func (b *Bot) EditFlashcard(chatID chatid) {
-	chatLogger := generateDialogLogger(chatID)
-	ioLogger := generateIoLogger(flashcardsFileName, "editFlashcard")
-	defer func() { b.InactiveInput <- chatID }()
-	fc := b.FlashcardsData
-
-	t, err := b.Dialog(chatID, "Podaj temat")
+// DeleteTopic starts dialog with user to choose topic and confirm it should be deleted. Then all flashcards of topic are deleted from store.
+func (b *Bot) DeleteTopic(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	topics, err := b.Store.Flashcards(chatID)
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
+		generateDialogLogger(chatID).Error("Could not load flashcards")
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.tryLater")}
 		return
 	}
-	t = strings.ToLower(t)
-	top := topic(t)
 
-	term, err := b.Dialog(chatID, "Podaj pojecie")
+	// question shows chosen topic and number of its flashcards
+	confirm := confirmStep(lang, "", lang.T("topic.notDeleted"))
+	confirm.Prompt = func(answers dialogAnswers) string {
+		return lang.T("topic.askConfirm", answers.Topic("topic"), len(topics[answers.Topic("topic")]))
+	}
+
+	answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+		{
+			Name:   "topic",
+			Prompt: prompt(lang.T("topic.ask")),
+			Options: func(dialogAnswers) []string {
+				names := []string{}
+				for top := range topics {
+					names = append(names, string(top))
+				}
+				sort.Strings(names)
+				return names
+			},
+			Parse: func(answer string, _ dialogAnswers) (interface{}, error) {
+				top := topic(strings.ToLower(answer))
+				if _, ok := topics[top]; !ok {
+					return nil, invalidAnswer(lang.T("topic.notFound"))
+				}
+				return top, nil
+			},
+		},
+		confirm,
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
 		return
 	}
-	term = strings.ToLower(term)
-	if _, ok := fc[chatID][top][term]; !ok {
-		b.Output <- Msg{chatID, "Fiszka nie istnieje"}
-		return
+
+	top := answers.Topic("topic")
+	for term := range topics[top] {
+		if err := b.Store.DeleteFlashcard(chatID, top, term); err != nil {
+			b.Output <- Msg{chatID: chatID, text: lang.T("error.saveFlashcard")}
+			return
+		}
 	}
 
-	definition, err := b.Dialog(chatID, "Podaj definicje")
+	b.Output <- Msg{chatID: chatID, text: lang.T("topic.deleted")}
+}
+
+// EditFlashcard starts dialog with user to check if given flashcard exists. If it exists, it's definition is edited and saved in store.
+func (b *Bot) EditFlashcard(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+		topicStep(lang),
+		b.termStep(chatID, lang, true),
+		{Name: "definition", Prompt: prompt(lang.T("flashcard.askDefinition"))},
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
 		return
 	}
 
-	fc[chatID][top][term] = definition
-	err = writeFlashcards(fc, ioLogger)
+	err = b.Store.PutFlashcard(chatID, answers.Topic("topic"), answers.String("term"), answers.String("definition"))
 	if err != nil {
-		b.Output <- Msg{chatID, "Wystapil problem, moga wystapic problemy z tym terminem w przyszlosci, skontaktuj sie z administratorem"}
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.saveFlashcard")}
+		return
 	}
 
-	b.FlashcardsData[chatID] = fc[chatID]
-	b.Output <- Msg{chatID, "Edytowano fiszke"}
+	b.Output <- Msg{chatID: chatID, text: lang.T("flashcard.edited")}
 }
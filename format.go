@@ -0,0 +1,36 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// textFormat tells how text of message is formatted.
+// In formatHTML text uses telegram HTML tags, e.g. <b> and <code>, and user's text has to be escaped.
+type textFormat int
+
+const (
+	formatPlain textFormat = iota
+	formatHTML
+)
+
+// bold returns escaped text in bold, for use in HTML messages.
+func bold(text string) string {
+	return "<b>" + html.EscapeString(text) + "</b>"
+}
+
+// monospace returns escaped text in monospace font, for use in HTML messages.
+func monospace(text string) string {
+	return "<code>" + html.EscapeString(text) + "</code>"
+}
+
+// stripHTML returns text of HTML message without tags and with unescaped entities, for front-ends which can't show formatting.
+func stripHTML(text string) string {
+	var b strings.Builder
+	for _, t := range tokenize(text, formatHTML) {
+		if !t.tag {
+			b.WriteString(html.UnescapeString(t.text))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"bold", bold("kot"), "<b>kot</b>"},
+		{"bold escapes", bold("<i>&"), "<b>&lt;i&gt;&amp;</b>"},
+		{"monospace escapes", monospace("a<b"), "<code>a&lt;b</code>"},
+		{"strip tags", stripHTML("<b>Poniedziałek</b>\n<code>08:00</code> - Analiza"), "Poniedziałek\n08:00 - Analiza"},
+		{"strip unescapes", stripHTML(bold("<i>&")), "<i>&"},
+		{"strip plain", stripHTML("ala ma kota"), "ala ma kota"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
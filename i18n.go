@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const languagesFileName = "languages.json"
+
+// language is a code of language in which bot talks with chat.
+type language string
+
+const (
+	polish  language = "pl"
+	english language = "en"
+)
+
+// defaultLanguage is used by chats which didn't choose language and for texts missing in other catalogs.
+const defaultLanguage = polish
+
+type languagesData map[chatid]language
+
+// languages returns all supported languages in order in which they are offered to user.
+func languages() []language {
+	return []language{polish, english}
+}
+
+// parseLanguage returns language with given code or name, e.g. "en" or "English".
+func parseLanguage(s string) (language, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, lang := range languages() {
+		if s == string(lang) || s == strings.ToLower(lang.T("language.name")) {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// T returns text with given key from language's catalog, formatted with args like fmt.Sprintf. Text missing in catalog is taken from default language, so bot never sends empty message.
+func (lang language) T(key string, args ...interface{}) string {
+	text, ok := catalogs[lang][key]
+	if !ok {
+		text, ok = catalogs[defaultLanguage][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// DateLayout returns layout in which dates are written and parsed in this language.
+func (lang language) DateLayout() string {
+	if lang == english {
+		return "2006-01-02 15:04"
+	}
+	return "02-01-06 15:04"
+}
+
+// catalogs keep all texts sent to users by language. Texts with verbs like %s and %d are formatted by T.
+var catalogs = map[language]map[string]string{
+	polish: {
+		"language.name":    "polski",
+		"language.ask":     "Wybierz język",
+		"language.unknown": "Nie znam takiego języka",
+		"language.set":     "Ustawiono język: polski",
+
+		"weekday.1": "poniedziałek",
+		"weekday.2": "wtorek",
+		"weekday.3": "środa",
+		"weekday.4": "czwartek",
+		"weekday.5": "piątek",
+		"weekday.6": "sobota",
+		"weekday.7": "niedziela",
+
+		"cmd.help":               "wypisuje listę komend",
+		"cmd.version":            "podaje aktualną wersję",
+		"cmd.flashcard":          "podaje fiszkę pod podaną nazwą",
+		"cmd.addFlashcard":       "uruchamia dialog dodawania fiszki",
+		"cmd.deleteFlashcard":    "uruchamia dialog usuwania fiszki",
+		"cmd.editFlashcard":      "uruchamia dialog edytowania fiszki",
+		"cmd.deleteTopic":        "uruchamia dialog usuwania tematu ze wszystkimi fiszkami",
+		"cmd.test":               "uruchamia test wiedzy",
+		"cmd.exam":               "uruchamia egzamin na czas",
+		"cmd.addReminder":        "uruchamia dialog dodawania przypomnienia",
+		"cmd.showReminders":      "wypisuje listę aktualnych przypomnień",
+		"cmd.addClass":           "uruchamia dialog dodawania zajęć",
+		"cmd.editClass":          "uruchamia dialog edytowania zajęć",
+		"cmd.deleteClass":        "uruchamia dialog usuwania zajęć",
+		"cmd.schedule":           "wypisuje plan zajęć",
+		"cmd.deleteSchedule":     "uruchamia dialog usuwania planu",
+		"cmd.language":           "zmienia język bota",
+		"cmd.exportData":         "wysyła wszystkie dane czatu zapisane przez bota",
+		"cmd.deleteData":         "usuwa wszystkie dane czatu",
+		"cmd.back":               "wraca do poprzedniego pytania w dialogu",
+		"cmd.cancel":             "przerywa aktualny dialog",
+		"cmd.admin":              "komendy administratora",
+		"args.flashcard":         "{nazwa}",
+		"args.exam":              "{temat} {ilość pytań} {minuty} [sekundy na pytanie]",
+		"args.language":          "[pl|en]",
+		"args.admin":             "stats|broadcast {tekst}|reload|dump {id czatu}",
+		"version":                "wersja %s",
+		"error.tryLater":         "Wystąpił błąd, spróbuj ponownie później",
+		"error.notAuthorized":    "Nie masz uprawnień do tej komendy",
+		"error.notGroupAdmin":    "W grupie tę komendę mogą uruchomić tylko administratorzy",
+		"error.rateLimited":      "Zwolnij trochę, spróbuj ponownie za %d s",
+		"error.saveFlashcard":    "Wystąpił problem, mogą wystąpić problemy z tym terminem w przyszłości, skontaktuj się z administratorem",
+		"error.saveReminder":     "Wystąpił problem, mogą wystąpić problemy z tym przypomnieniem w przyszłości, skontaktuj się z administratorem",
+		"error.saveClass":        "Wystąpił problem, mogą wystąpić problemy z tymi zajęciami w przyszłości, skontaktuj się z administratorem",
+		"dialog.timeout":         "Przekroczono czas odpowiedzi",
+		"dialog.tooManyRetries":  "Zbyt wiele błędnych odpowiedzi, zacznij od nowa",
+		"dialog.cancelled":       "Anulowano",
+		"dialog.nothingToCancel": "Nie ma czego anulować",
+		"dialog.noDialog":        "Nie ma aktywnego dialogu",
+		"dialog.shutdown":        "Bot jest wyłączany, dialog został przerwany. Spróbuj ponownie za chwilę",
+		"dialog.confirmWord":     "TAK",
+		"dialog.tooLong":         "Odpowiedź jest za długa, maksymalnie %d znaków",
+
+		"topic.ask":        "Podaj temat",
+		"topic.notFound":   "Temat nie istnieje",
+		"topic.askConfirm": "Napisz 'TAK', żeby usunąć temat %s razem z fiszkami (%d)",
+		"topic.notDeleted": "Ok, nie usuwamy",
+		"topic.deleted":    "Usunięto temat",
+
+		"flashcard.askTerm":       "Podaj pojęcie",
+		"flashcard.askDefinition": "Podaj definicję",
+		"flashcard.notFound":      "Fiszka nie istnieje",
+		"flashcard.exists":        "Fiszka już istnieje, edytuj ją za pomocą /edytujfiszke",
+		"flashcard.added":         "Dodano fiszkę",
+		"flashcard.deleted":       "Usunięto fiszkę",
+		"flashcard.edited":        "Edytowano fiszkę",
+		"flashcard.termMissing":   "Podaj pojęcie po spacji",
+		"flashcard.termNotFound":  "Nie znaleziono pojęcia",
+		"flashcard.limit":         "Masz już maksymalną liczbę fiszek (%d), usuń niepotrzebne, żeby dodać nowe",
+
+		"test.intro":      "Test wiedzy z twoich fiszek. Będę podawał definicje różnych pojęć, a ty odpowiedz nazwą pojęcia. Na początek podaj temat, z którego chcesz zostać przepytany.",
+		"test.askRange":   "Podaj liczbę pytań, maksymalna liczba dla tego tematu: %d",
+		"test.needNumber": "Musisz podać liczbę",
+		"test.question":   "Co to jest? %s",
+		"test.correct":    "Poprawna odpowiedź",
+		"test.wrong":      "Błędna odpowiedź, poprawna to: %s",
+		"test.result":     "Odpowiedziałeś poprawnie na %d z %d",
+
+		"exam.usage":           "Użycie: /egzamin {temat} {ilość pytań} {minuty} [sekundy na pytanie]",
+		"exam.tooLong":         "Egzamin może trwać najwyżej %d minut",
+		"exam.start":           "Egzamin z tematu %s: %d pytań, czas: %s",
+		"exam.questionLimit":   ", na każde pytanie masz %d s",
+		"exam.question":        "Pytanie %d/%d, pozostały czas: %s\nCo to jest? %s",
+		"exam.questionTimeout": "Czas na to pytanie minął",
+		"exam.timeUp":          "Koniec czasu, egzamin został zakończony automatycznie",
+		"exam.result":          "Wynik: %d/%d (%s%%)\nBez odpowiedzi: %d\nOcena: %s",
+
+		"reminder.askDate":  "Podaj datę w formacie DD-MM-RR GG:MM",
+		"reminder.badDate":  "Niepoprawny format daty",
+		"reminder.pastDate": "Data jest z przeszłości, spróbuj ponownie",
+		"reminder.askTitle": "Podaj tytuł przypomnienia",
+		"reminder.added":    "Dodano przypomnienie",
+		"reminder.current":  "Aktualne przypomnienia:",
+		"reminder.remind":   "Przypominam: %s %s",
+		"reminder.limit":    "Masz już maksymalną liczbę przypomnień (%d)",
+
+		"schedule.askWeekday":     "Podaj dzień tygodnia",
+		"schedule.unknownWeekday": "Nie znam takiego dnia :(",
+		"schedule.askStart":       "Podaj godzinę rozpoczęcia w formacie GG:MM",
+		"schedule.askEnd":         "Podaj godzinę zakończenia w formacie GG:MM",
+		"schedule.badHour":        "Niepoprawny format godziny",
+		"schedule.endBeforeStart": "Zajęcia nie mogą się kończyć przed rozpoczęciem :/",
+		"schedule.askName":        "Podaj nazwę",
+		"schedule.classNotFound":  "Podane zajęcia nie istnieją",
+		"schedule.classExists":    "Podane zajęcia są już zapisane",
+		"schedule.classAdded":     "Dodano zajęcia",
+		"schedule.classEdited":    "Edytowano zajęcia",
+		"schedule.classDeleted":   "Usunięto zajęcia",
+		"schedule.askConfirm":     "Napisz 'TAK', żeby usunąć plan",
+		"schedule.notDeleted":     "Ok, nie usuwamy",
+		"schedule.deleted":        "Usunięto plan",
+		"schedule.empty":          "Brak zajęć :/",
+
+		"data.exportCaption": "Wszystkie dane tego czatu zapisane przez bota",
+		"console.fileSaved":  "[zapisano plik %s]",
+		"data.askConfirm":    "Napisz 'TAK', żeby nieodwracalnie usunąć wszystkie fiszki, przypomnienia, plan zajęć i ustawienia tego czatu, także z kopii zapasowych",
+		"data.notDeleted":    "Ok, nie usuwamy",
+		"data.deleted":       "Usunięto wszystkie dane czatu. Nie obejmuje to archiwów kopii zapasowych wykonanych wcześniej przez administratora bota",
+		"data.deleteFailed":  "Nie udało się usunąć wszystkich danych, spróbuj ponownie lub skontaktuj się z administratorem",
+
+		"admin.usage":           "Użycie: /admin stats | broadcast {tekst} | reload | dump {id czatu}",
+		"admin.stats":           "Czaty: %d\nTematy: %d\nFiszki: %d\nPrzypomnienia: %d\nZajęcia: %d\nWiadomości do wysłania: %d",
+		"admin.broadcastSent":   "Wysłano wiadomość do %d czatów",
+		"admin.reloaded":        "Wczytano dane ponownie",
+		"admin.reloadFailed":    "Nie udało się wczytać danych: %s",
+		"admin.dumpCaption":     "Dane czatu %d",
+		"admin.dumpPrivateOnly": "Dane czatu mogę wysłać tylko w prywatnej rozmowie ze mną",
+	},
+	english: {
+		"language.name":    "English",
+		"language.ask":     "Choose language",
+		"language.unknown": "I don't know this language",
+		"language.set":     "Language set to English",
+
+		"weekday.1": "monday",
+		"weekday.2": "tuesday",
+		"weekday.3": "wednesday",
+		"weekday.4": "thursday",
+		"weekday.5": "friday",
+		"weekday.6": "saturday",
+		"weekday.7": "sunday",
+
+		"cmd.help":               "lists commands",
+		"cmd.version":            "shows current version",
+		"cmd.flashcard":          "shows flashcard with given term",
+		"cmd.addFlashcard":       "starts dialog adding flashcard",
+		"cmd.deleteFlashcard":    "starts dialog deleting flashcard",
+		"cmd.editFlashcard":      "starts dialog editing flashcard",
+		"cmd.deleteTopic":        "starts dialog deleting topic with all its flashcards",
+		"cmd.test":               "starts knowledge test",
+		"cmd.exam":               "starts timed exam",
+		"cmd.addReminder":        "starts dialog adding reminder",
+		"cmd.showReminders":      "lists current reminders",
+		"cmd.addClass":           "starts dialog adding class",
+		"cmd.editClass":          "starts dialog editing class",
+		"cmd.deleteClass":        "starts dialog deleting class",
+		"cmd.schedule":           "shows schedule",
+		"cmd.deleteSchedule":     "starts dialog deleting schedule",
+		"cmd.language":           "changes bot's language",
+		"cmd.exportData":         "sends all data of this chat stored by bot",
+		"cmd.deleteData":         "deletes all data of this chat",
+		"cmd.back":               "goes back to previous question of dialog",
+		"cmd.cancel":             "cancels current dialog",
+		"cmd.admin":              "admin commands",
+		"args.flashcard":         "{term}",
+		"args.exam":              "{topic} {number of questions} {minutes} [seconds per question]",
+		"args.language":          "[pl|en]",
+		"args.admin":             "stats|broadcast {text}|reload|dump {chat id}",
+		"version":                "version %s",
+		"error.tryLater":         "Something went wrong, try again later",
+		"error.notAuthorized":    "You are not allowed to use this command",
+		"error.notGroupAdmin":    "In groups only admins can use this command",
+		"error.rateLimited":      "Slow down a bit, try again in %d s",
+		"error.saveFlashcard":    "Something went wrong, there may be problems with this term in the future, contact administrator",
+		"error.saveReminder":     "Something went wrong, there may be problems with this reminder in the future, contact administrator",
+		"error.saveClass":        "Something went wrong, there may be problems with this class in the future, contact administrator",
+		"dialog.timeout":         "Time for answer is up",
+		"dialog.tooManyRetries":  "Too many invalid answers, start again",
+		"dialog.cancelled":       "Cancelled",
+		"dialog.nothingToCancel": "There is nothing to cancel",
+		"dialog.noDialog":        "There is no active dialog",
+		"dialog.shutdown":        "Bot is shutting down, dialog was interrupted. Try again in a moment",
+		"dialog.confirmWord":     "YES",
+		"dialog.tooLong":         "Answer is too long, maximum is %d characters",
+
+		"topic.ask":        "Enter topic",
+		"topic.notFound":   "Topic doesn't exist",
+		"topic.askConfirm": "Write 'YES' to delete topic %s with its flashcards (%d)",
+		"topic.notDeleted": "Ok, not deleting",
+		"topic.deleted":    "Topic deleted",
+
+		"flashcard.askTerm":       "Enter term",
+		"flashcard.askDefinition": "Enter definition",
+		"flashcard.notFound":      "Flashcard doesn't exist",
+		"flashcard.exists":        "Flashcard already exists, edit it with /edytujfiszke",
+		"flashcard.added":         "Flashcard added",
+		"flashcard.deleted":       "Flashcard deleted",
+		"flashcard.edited":        "Flashcard edited",
+		"flashcard.termMissing":   "Write term after space",
+		"flashcard.termNotFound":  "Term not found",
+		"flashcard.limit":         "You already have maximum number of flashcards (%d), delete some to add new ones",
+
+		"test.intro":      "Knowledge test from your flashcards. I will send definitions of different terms and you answer with the term. First enter topic you want to be tested from.",
+		"test.askRange":   "Enter number of questions, maximum for this topic: %d",
+		"test.needNumber": "You have to enter a number",
+		"test.question":   "What is it? %s",
+		"test.correct":    "Correct answer",
+		"test.wrong":      "Wrong answer, correct one is: %s",
+		"test.result":     "You answered %d of %d correctly",
+
+		"exam.usage":           "Usage: /egzamin {topic} {number of questions} {minutes} [seconds per question]",
+		"exam.tooLong":         "Exam can last at most %d minutes",
+		"exam.start":           "Exam from topic %s: %d questions, time: %s",
+		"exam.questionLimit":   ", you have %d s for every question",
+		"exam.question":        "Question %d/%d, time left: %s\nWhat is it? %s",
+		"exam.questionTimeout": "Time for this question is up",
+		"exam.timeUp":          "Time is up, exam was finished automatically",
+		"exam.result":          "Result: %d/%d (%s%%)\nNot answered: %d\nGrade: %s",
+
+		"reminder.askDate":  "Enter date in format YYYY-MM-DD HH:MM",
+		"reminder.badDate":  "Invalid date format",
+		"reminder.pastDate": "Date is in the past, try again",
+		"reminder.askTitle": "Enter title of reminder",
+		"reminder.added":    "Reminder added",
+		"reminder.current":  "Current reminders:",
+		"reminder.remind":   "Reminder: %s %s",
+		"reminder.limit":    "You already have maximum number of reminders (%d)",
+
+		"schedule.askWeekday":     "Enter day of the week",
+		"schedule.unknownWeekday": "I don't know this day :(",
+		"schedule.askStart":       "Enter start hour in format HH:MM",
+		"schedule.askEnd":         "Enter end hour in format HH:MM",
+		"schedule.badHour":        "Invalid hour format",
+		"schedule.endBeforeStart": "Class can't end before it starts :/",
+		"schedule.askName":        "Enter name",
+		"schedule.classNotFound":  "This class doesn't exist",
+		"schedule.classExists":    "This class is already saved",
+		"schedule.classAdded":     "Class added",
+		"schedule.classEdited":    "Class edited",
+		"schedule.classDeleted":   "Class deleted",
+		"schedule.askConfirm":     "Write 'YES' to delete schedule",
+		"schedule.notDeleted":     "Ok, not deleting",
+		"schedule.deleted":        "Schedule deleted",
+		"schedule.empty":          "No classes :/",
+
+		"data.exportCaption": "All data of this chat stored by bot",
+		"console.fileSaved":  "[file %s saved]",
+		"data.askConfirm":    "Write 'YES' to permanently delete all flashcards, reminders, schedule and settings of this chat, also from backups",
+		"data.notDeleted":    "Ok, not deleting",
+		"data.deleted":       "All data of chat deleted. It doesn't cover backup archives made earlier by bot's administrator",
+		"data.deleteFailed":  "Could not delete all data, try again or contact administrator",
+
+		"admin.usage":           "Usage: /admin stats | broadcast {text} | reload | dump {chat id}",
+		"admin.stats":           "Chats: %d\nTopics: %d\nFlashcards: %d\nReminders: %d\nClasses: %d\nMessages to send: %d",
+		"admin.broadcastSent":   "Message sent to %d chats",
+		"admin.reloaded":        "Data reloaded",
+		"admin.reloadFailed":    "Could not reload data: %s",
+		"admin.dumpCaption":     "Data of chat %d",
+		"admin.dumpPrivateOnly": "I can send chat data only in private chat with me",
+	},
+}
+
+// Language returns language chosen in chat, or default language if chat didn't choose any or it could not be loaded.
+func (b *Bot) Language(chatID chatid) language {
+	lang, err := b.Store.Language(chatID)
+	if err != nil {
+		generateDialogLogger(chatID).Error("Could not load language")
+		return defaultLanguage
+	}
+	if _, ok := catalogs[lang]; !ok {
+		return defaultLanguage
+	}
+	return lang
+}
+
+// T returns text with given key in language of chat.
+func (b *Bot) T(chatID chatid, key string, args ...interface{}) string {
+	return b.Language(chatID).T(key, args...)
+}
+
+// SetLanguage changes language of chat. If payload is empty, it asks user to choose language from keyboard.
+func (b *Bot) SetLanguage(ctx context.Context, chatID chatid, payload string) {
+	lang := b.Language(chatID)
+
+	var chosen language
+	if payload != "" {
+		var ok bool
+		if chosen, ok = parseLanguage(payload); !ok {
+			b.Output <- Msg{chatID: chatID, text: lang.T("language.unknown")}
+			return
+		}
+	} else {
+		answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+			{
+				Name:   "language",
+				Prompt: prompt(lang.T("language.ask")),
+				Options: func(dialogAnswers) []string {
+					names := []string{}
+					for _, l := range languages() {
+						names = append(names, l.T("language.name"))
+					}
+					return names
+				},
+				Parse: func(answer string, _ dialogAnswers) (interface{}, error) {
+					l, ok := parseLanguage(answer)
+					if !ok {
+						return nil, invalidAnswer(lang.T("language.unknown"))
+					}
+					return l, nil
+				},
+			},
+		})
+		if err != nil {
+			return
+		}
+		chosen = answers.Language("language")
+	}
+
+	if err := b.Store.SetLanguage(chatID, chosen); err != nil {
+		generateDialogLogger(chatID).Error("Could not save language")
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.tryLater")}
+		return
+	}
+	b.Output <- Msg{chatID: chatID, text: chosen.T("language.set")}
+}
@@ -0,0 +1,662 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// jsonStore is a Store which keeps all data in memory and atomically rewrites json file of given kind after every change. Single mutex guards all data, so writes don't race with each other. Files are encrypted if keys are configured.
+type jsonStore struct {
+	mu             sync.Mutex
+	backups        int
+	keys           *keyring
+	dirty          map[string]bool
+	flashcards     flashcardsData
+	reminders      remindersData
+	schedules      schedulesData
+	outbox         []pendingMessage
+	languages      languagesData
+	flashcardsFile string
+	remindersFile  string
+	schedulesFile  string
+	outboxFile     string
+	languagesFile  string
+}
+
+// writeJSONFile atomically rewrites json file with v in current format of given kind, keeping given number of backups. File is encrypted with primary key of keys.
+func writeJSONFile(fileName string, kind string, v interface{}, backups int, keys *keyring, ioLogger *log.Entry) error {
+	data, err := encodeVersionedData(kind, v)
+	if err != nil {
+		ioLogger.Error("Could not encode data")
+		return err
+	}
+	if data, err = keys.seal(data); err != nil {
+		ioLogger.Error("Could not encrypt data")
+		return err
+	}
+
+	err = writeDataFile(fileName, data, backups)
+	if err != nil {
+		ioLogger.Error("Could not write file: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// loadJSONFile reads json file of given kind into value returned by reset. Reset returns pointer to empty value and is called before every attempt, so broken file can't leave anything in data loaded from backup. Data in older format is migrated and file is rewritten, old version stays in backups. Encrypted files are decrypted with keys, plaintext files are read as they are.
+func loadJSONFile(fileName string, kind string, backups int, keys *keyring, reset func() interface{}) error {
+	ioLogger := generateIoLogger(fileName, "loadJSONFile")
+
+	// file encrypted with missing key or written by newer bot isn't broken, loading older backup instead would lose data
+	if content, err := ioutil.ReadFile(fileName); err == nil {
+		if isSealed(content) {
+			if content, err = keys.open(content); err != nil {
+				return errors.New(fileName + ": " + err.Error())
+			}
+		}
+		if version, _, err := unwrapData(content); err == nil {
+			if err := checkDataVersion(kind, version); err != nil {
+				return errors.New(fileName + ": " + err.Error())
+			}
+		}
+	}
+
+	// new file gets empty value of its data
+	empty, err := encodeVersionedData(kind, reset())
+	if err != nil {
+		return err
+	}
+	if empty, err = keys.seal(empty); err != nil {
+		return err
+	}
+
+	var v interface{}
+	migrated := false
+	err = readDataFile(fileName, backups, empty, func(content []byte) error {
+		v = reset()
+		m, err := decodeJSONFile(content, kind, keys, v)
+		migrated = m
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if migrated {
+		ioLogger.WithFields(log.Fields{
+			"version": currentDataVersion(kind),
+		}).Info("Migrated data file")
+		return writeJSONFile(fileName, kind, v, backups, keys, ioLogger)
+	}
+	return nil
+}
+
+// decodeJSONFile decrypts content of json file of given kind, migrates it to current format and decodes it into v. It reports if data was in older format.
+func decodeJSONFile(content []byte, kind string, keys *keyring, v interface{}) (bool, error) {
+	content, err := keys.open(content)
+	if err != nil {
+		return false, err
+	}
+	data, migrated, err := decodeVersionedData(kind, content)
+	if err != nil {
+		return false, err
+	}
+	return migrated, json.Unmarshal(data, v)
+}
+
+// newJSONStore loads data from files given in config. Every file keeps configured number of backups, which are used if file is broken.
+func newJSONStore(sc storageConfig) (*jsonStore, error) {
+	backups := sc.Backups
+	s := &jsonStore{
+		backups:        backups,
+		keys:           sc.keys,
+		dirty:          make(map[string]bool),
+		flashcardsFile: sc.FlashcardsFile,
+		remindersFile:  sc.RemindersFile,
+		schedulesFile:  sc.SchedulesFile,
+		outboxFile:     sc.OutboxFile,
+		languagesFile:  sc.LanguagesFile,
+	}
+
+	err := loadJSONFile(s.flashcardsFile, flashcardsKind, backups, s.keys, func() interface{} {
+		s.flashcards = make(flashcardsData)
+		return &s.flashcards
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = loadJSONFile(s.remindersFile, remindersKind, backups, s.keys, func() interface{} {
+		s.reminders = make(remindersData)
+		return &s.reminders
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = loadJSONFile(s.schedulesFile, schedulesKind, backups, s.keys, func() interface{} {
+		s.schedules = make(schedulesData)
+		return &s.schedules
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = loadJSONFile(s.outboxFile, outboxKind, backups, s.keys, func() interface{} {
+		s.outbox = nil
+		return &s.outbox
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = loadJSONFile(s.languagesFile, languagesKind, backups, s.keys, func() interface{} {
+		s.languages = make(languagesData)
+		return &s.languages
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.ensureMaps()
+	return s, nil
+}
+
+// newMemoryJSONStore creates json store without files, e.g. for data read from backup archive. It can be read and imported into other store, but not changed.
+func newMemoryJSONStore() *jsonStore {
+	s := &jsonStore{dirty: make(map[string]bool)}
+	s.ensureMaps()
+	return s
+}
+
+// ensureMaps creates maps which are nil, because file with null data decodes to nil map.
+func (s *jsonStore) ensureMaps() {
+	if s.flashcards == nil {
+		s.flashcards = make(flashcardsData)
+	}
+	if s.reminders == nil {
+		s.reminders = make(remindersData)
+	}
+	if s.schedules == nil {
+		s.schedules = make(schedulesData)
+	}
+	if s.languages == nil {
+		s.languages = make(languagesData)
+	}
+}
+
+// save writes data file and remembers if it failed, so Close can try again.
+func (s *jsonStore) save(fileName string, kind string, v interface{}, funcname string) error {
+	err := writeJSONFile(fileName, kind, v, s.backups, s.keys, generateIoLogger(fileName, funcname))
+	s.dirty[fileName] = err != nil
+	return err
+}
+
+// Flashcards returns all flashcards of chat grouped by topic.
+func (s *jsonStore) Flashcards(chatID chatid) (map[topic]flashcards, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyTopics(s.flashcards[chatID]), nil
+}
+
+// Flashcard returns definition of term in given topic and reports if it exists.
+func (s *jsonStore) Flashcard(chatID chatid, top topic, term string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	definition, ok := s.flashcards[chatID][top][term]
+	return definition, ok, nil
+}
+
+// PutFlashcard adds flashcard or replaces its definition.
+func (s *jsonStore) PutFlashcard(chatID chatid, top topic, term string, definition string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.flashcards[chatID] == nil {
+		s.flashcards[chatID] = make(map[topic]flashcards)
+	}
+	if s.flashcards[chatID][top] == nil {
+		s.flashcards[chatID][top] = make(flashcards)
+	}
+	s.flashcards[chatID][top][term] = definition
+
+	return s.save(s.flashcardsFile, flashcardsKind, s.flashcards, "putFlashcard")
+}
+
+// DeleteFlashcard deletes flashcard and its topic if it was the last one.
+func (s *jsonStore) DeleteFlashcard(chatID chatid, top topic, term string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.flashcards[chatID][top], term)
+	if len(s.flashcards[chatID][top]) == 0 {
+		delete(s.flashcards[chatID], top)
+	}
+	if len(s.flashcards[chatID]) == 0 {
+		delete(s.flashcards, chatID)
+	}
+
+	return s.save(s.flashcardsFile, flashcardsKind, s.flashcards, "deleteFlashcard")
+}
+
+// Reminders returns all reminders of chat.
+func (s *jsonStore) Reminders(chatID chatid) ([]Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Reminder{}, s.reminders[chatID]...), nil
+}
+
+// AllReminders returns reminders of every chat.
+func (s *jsonStore) AllReminders() (remindersData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rd := make(remindersData, len(s.reminders))
+	for chatID, perChatR := range s.reminders {
+		rd[chatID] = append([]Reminder{}, perChatR...)
+	}
+	return rd, nil
+}
+
+// AddReminder adds reminder to chat.
+func (s *jsonStore) AddReminder(chatID chatid, r Reminder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reminders[chatID] = append(s.reminders[chatID], r)
+
+	return s.save(s.remindersFile, remindersKind, s.reminders, "addReminder")
+}
+
+// DeleteReminder deletes reminder with the same date and title.
+func (s *jsonStore) DeleteReminder(chatID chatid, r Reminder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rd := s.reminders[chatID]
+	for i, rmndr := range rd {
+		if rmndr.Date.Equal(r.Date) && rmndr.Title == r.Title {
+			s.reminders[chatID] = append(rd[:i:i], rd[i+1:]...)
+			break
+		}
+	}
+	if len(s.reminders[chatID]) == 0 {
+		delete(s.reminders, chatID)
+	}
+
+	return s.save(s.remindersFile, remindersKind, s.reminders, "deleteReminder")
+}
+
+// Schedule returns schedule of chat.
+func (s *jsonStore) Schedule(chatID chatid) (schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copySchedule(s.schedules[chatID]), nil
+}
+
+// SetSchoolDay replaces all classes of given weekday.
+func (s *jsonStore) SetSchoolDay(chatID chatid, wd Weekday, day schoolDay) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.schedules[chatID] == nil {
+		s.schedules[chatID] = schedule{}
+	}
+	s.schedules[chatID][wd] = append(schoolDay{}, day...)
+
+	return s.save(s.schedulesFile, schedulesKind, s.schedules, "setSchoolDay")
+}
+
+// DeleteSchedule deletes all classes of chat.
+func (s *jsonStore) DeleteSchedule(chatID chatid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.schedules, chatID)
+
+	return s.save(s.schedulesFile, schedulesKind, s.schedules, "deleteSchedule")
+}
+
+// Language returns language chosen in chat, empty if chat didn't choose any.
+func (s *jsonStore) Language(chatID chatid) (language, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.languages[chatID], nil
+}
+
+// SetLanguage saves language chosen in chat.
+func (s *jsonStore) SetLanguage(chatID chatid, lang language) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.languages[chatID] = lang
+
+	return s.save(s.languagesFile, languagesKind, s.languages, "setLanguage")
+}
+
+// PendingMessages returns messages which were not delivered yet, oldest first.
+func (s *jsonStore) PendingMessages() ([]pendingMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]pendingMessage{}, s.outbox...), nil
+}
+
+// AddPendingMessage saves message which has to be delivered even if bot restarts.
+func (s *jsonStore) AddPendingMessage(m pendingMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outbox = append(s.outbox, m)
+
+	return s.save(s.outboxFile, outboxKind, s.outbox, "addPendingMessage")
+}
+
+// DeletePendingMessage deletes delivered message.
+func (s *jsonStore) DeletePendingMessage(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, m := range s.outbox {
+		if m.ID == id {
+			s.outbox = append(s.outbox[:i:i], s.outbox[i+1:]...)
+			return s.save(s.outboxFile, outboxKind, s.outbox, "deletePendingMessage")
+		}
+	}
+	return nil
+}
+
+// scrubBackups removes data of chat from every backup of file and from its broken copies. Scrub decodes data in current format, removes chat and returns what is left. Copy which can't be decoded, e.g. because it is encrypted with removed key, is left as it is and logged, so operator can delete it. Deleting it here could lose the only copy of other chats' data.
+func scrubBackups(fileName string, kind string, backups int, keys *keyring, scrub func(data []byte) (interface{}, error)) error {
+	ioLogger := generateIoLogger(fileName, "scrubBackups")
+
+	var firstErr error
+	copies := []string{}
+	for n := 1; n <= backups; n++ {
+		copies = append(copies, backupFileName(fileName, n))
+	}
+	broken, err := filepath.Glob(fileName + ".broken-*")
+	if err != nil {
+		firstErr = err
+	}
+	copies = append(copies, broken...)
+
+	for _, c := range copies {
+		content, err := ioutil.ReadFile(c)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		var v interface{}
+		if err == nil {
+			content, err = keys.open(content)
+		}
+		if err == nil {
+			var data json.RawMessage
+			if data, _, err = decodeVersionedData(kind, content); err == nil {
+				v, err = scrub(data)
+			}
+		}
+		if err != nil {
+			ioLogger.WithField("copy", c).Error("Could not remove chat from copy of file, it has to be deleted by hand: " + err.Error())
+			continue
+		}
+
+		if err := writeJSONFile(c, kind, v, 0, keys, ioLogger); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// DeleteChat deletes all data of chat, writes all files and then removes chat from their backups.
+func (s *jsonStore) DeleteChat(chatID chatid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.flashcards, chatID)
+	delete(s.reminders, chatID)
+	delete(s.schedules, chatID)
+	delete(s.languages, chatID)
+	outbox := []pendingMessage{}
+	for _, m := range s.outbox {
+		if m.ChatID != chatID {
+			outbox = append(outbox, m)
+		}
+	}
+	s.outbox = outbox
+
+	files := []struct {
+		name  string
+		kind  string
+		data  interface{}
+		scrub func(data []byte) (interface{}, error)
+	}{
+		{s.flashcardsFile, flashcardsKind, s.flashcards, func(data []byte) (interface{}, error) {
+			var fd flashcardsData
+			err := json.Unmarshal(data, &fd)
+			delete(fd, chatID)
+			return fd, err
+		}},
+		{s.remindersFile, remindersKind, s.reminders, func(data []byte) (interface{}, error) {
+			var rd remindersData
+			err := json.Unmarshal(data, &rd)
+			delete(rd, chatID)
+			return rd, err
+		}},
+		{s.schedulesFile, schedulesKind, s.schedules, func(data []byte) (interface{}, error) {
+			var sd schedulesData
+			err := json.Unmarshal(data, &sd)
+			delete(sd, chatID)
+			return sd, err
+		}},
+		{s.outboxFile, outboxKind, s.outbox, func(data []byte) (interface{}, error) {
+			var pms []pendingMessage
+			err := json.Unmarshal(data, &pms)
+			left := []pendingMessage{}
+			for _, m := range pms {
+				if m.ChatID != chatID {
+					left = append(left, m)
+				}
+			}
+			return left, err
+		}},
+		{s.languagesFile, languagesKind, s.languages, func(data []byte) (interface{}, error) {
+			var ld languagesData
+			err := json.Unmarshal(data, &ld)
+			delete(ld, chatID)
+			return ld, err
+		}},
+	}
+
+	// files are written first, so their previous versions with chat's data become backups and are scrubbed as well
+	var firstErr error
+	for _, f := range files {
+		if err := s.save(f.name, f.kind, f.data, "deleteChat"); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, f := range files {
+		if err := scrubBackups(f.name, f.kind, s.backups, s.keys, f.scrub); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Chats returns IDs of all chats which have any data, sorted.
+func (s *jsonStore) Chats() ([]chatid, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.chats(), nil
+}
+
+// chats returns IDs of all chats which have any data, sorted. Caller has to hold the lock.
+func (s *jsonStore) chats() []chatid {
+	seen := make(map[chatid]bool)
+	for chatID := range s.flashcards {
+		seen[chatID] = true
+	}
+	for chatID := range s.reminders {
+		seen[chatID] = true
+	}
+	for chatID := range s.schedules {
+		seen[chatID] = true
+	}
+	for chatID := range s.languages {
+		seen[chatID] = true
+	}
+
+	chats := make([]chatid, 0, len(seen))
+	for chatID := range seen {
+		chats = append(chats, chatID)
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i] < chats[j] })
+	return chats
+}
+
+// Stats counts data of all chats.
+func (s *jsonStore) Stats() (storeStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := storeStats{Chats: len(s.chats()), PendingMessages: len(s.outbox)}
+	for _, topics := range s.flashcards {
+		st.Topics += len(topics)
+		for _, fc := range topics {
+			st.Flashcards += len(fc)
+		}
+	}
+	for _, rmndrs := range s.reminders {
+		st.Reminders += len(rmndrs)
+	}
+	for _, sd := range s.schedules {
+		for _, day := range sd {
+			st.Classes += len(day)
+		}
+	}
+	return st, nil
+}
+
+// Reload reads all files again and replaces data in memory. It refuses to reload if some changes were not written yet, because they would be lost. Store is locked for the whole reload, so no change can be made between reading files and replacing data.
+func (s *jsonStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if files := s.dirtyFiles(); len(files) > 0 {
+		return errors.New("not reloading, changes would be lost: could not write " + strings.Join(files, ", "))
+	}
+
+	sc := storageConfig{
+		FlashcardsFile: s.flashcardsFile,
+		RemindersFile:  s.remindersFile,
+		SchedulesFile:  s.schedulesFile,
+		OutboxFile:     s.outboxFile,
+		LanguagesFile:  s.languagesFile,
+		Backups:        s.backups,
+		keys:           s.keys,
+	}
+	fresh, err := newJSONStore(sc)
+	if err != nil {
+		return err
+	}
+
+	s.flashcards = fresh.flashcards
+	s.reminders = fresh.reminders
+	s.schedules = fresh.schedules
+	s.outbox = fresh.outbox
+	s.languages = fresh.languages
+	s.dirty = make(map[string]bool)
+	return nil
+}
+
+// ImportJSON copies all data from other json store, e.g. read from backup archive, and writes all files. Like database, it refuses to import into files which already have any data, so import can't overwrite them by mistake.
+func (s *jsonStore) ImportJSON(js *jsonStore) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.chats()) > 0 || len(s.outbox) > 0 {
+		return errors.New("data files are not empty, import has to be done into new files")
+	}
+
+	js.mu.Lock()
+	s.flashcards = js.flashcards
+	s.reminders = js.reminders
+	s.schedules = js.schedules
+	s.outbox = js.outbox
+	s.languages = js.languages
+	js.mu.Unlock()
+
+	files := []struct {
+		name string
+		kind string
+		data interface{}
+	}{
+		{s.flashcardsFile, flashcardsKind, s.flashcards},
+		{s.remindersFile, remindersKind, s.reminders},
+		{s.schedulesFile, schedulesKind, s.schedules},
+		{s.outboxFile, outboxKind, s.outbox},
+		{s.languagesFile, languagesKind, s.languages},
+	}
+
+	var firstErr error
+	for _, f := range files {
+		if err := s.save(f.name, f.kind, f.data, "importJSON"); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Ping reports files which could not be written after last change.
+func (s *jsonStore) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if files := s.dirtyFiles(); len(files) > 0 {
+		return errors.New("could not write " + strings.Join(files, ", "))
+	}
+	return nil
+}
+
+// dirtyFiles returns sorted names of files which could not be written after last change. Store has to be locked.
+func (s *jsonStore) dirtyFiles() []string {
+	files := []string{}
+	for name, dirty := range s.dirty {
+		if dirty {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Close writes again files which could not be written after last change. Other files already have every change.
+func (s *jsonStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := []struct {
+		name string
+		kind string
+		data interface{}
+	}{
+		{s.flashcardsFile, flashcardsKind, s.flashcards},
+		{s.remindersFile, remindersKind, s.reminders},
+		{s.schedulesFile, schedulesKind, s.schedules},
+		{s.outboxFile, outboxKind, s.outbox},
+		{s.languagesFile, languagesKind, s.languages},
+	}
+
+	var firstErr error
+	for _, f := range files {
+		if !s.dirty[f.name] {
+			continue
+		}
+		if err := s.save(f.name, f.kind, f.data, "close"); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLoadJSONFileNewerVersion(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), flashcardsFileName)
+	newer := []byte(`{"version":` + strconv.Itoa(currentDataVersion(flashcardsKind)+1) + `,"data":{}}`)
+	backup, err := encodeVersionedData(flashcardsKind, flashcardsData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{fileName: newer, backupFileName(fileName, 1): backup}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = loadJSONFile(fileName, flashcardsKind, defaultDataBackups, nil, func() interface{} {
+		return &flashcardsData{}
+	})
+	if err == nil {
+		t.Fatal("file written by newer bot was loaded")
+	}
+
+	// neither file nor backup can be touched, so newer bot can still read them
+	for name, content := range files {
+		got, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(content) {
+			t.Errorf("%s was changed to %s", filepath.Base(name), got)
+		}
+	}
+	if broken, _ := filepath.Glob(fileName + ".broken-*"); len(broken) != 0 {
+		t.Errorf("file was moved aside: %v", broken)
+	}
+}
+
+func TestJSONStoreReload(t *testing.T) {
+	sc := testStorageConfig(t.TempDir())
+	s, err := newJSONStore(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// other process changes files
+	other, err := newJSONStore(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.PutFlashcard(1, "biologia", "mitochondrium", "centrum energetyczne komórki"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.dirty[sc.RemindersFile] = true
+	if err := s.Reload(); err == nil {
+		t.Fatal("store was reloaded with unwritten changes")
+	}
+
+	s.dirty[sc.RemindersFile] = false
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.Flashcard(1, "biologia", "mitochondrium"); !ok {
+		t.Error("flashcard added to file was not loaded")
+	}
+	if len(s.dirty) != 0 {
+		t.Errorf("dirty = %v after reload", s.dirty)
+	}
+}
+
+func TestDeleteChatScrubsBackups(t *testing.T) {
+	sc := testStorageConfig(t.TempDir())
+	sc.Backups = 3
+	s, err := newJSONStore(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutFlashcard(1, "biologia", "mitochondrium", "centrum energetyczne komórki"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutFlashcard(2, "chemia", "sód", "Na"); err != nil {
+		t.Fatal(err)
+	}
+
+	// copies which can't be read may keep data of other chats, so they have to stay, broken backup only moves to .bak.3 when file is written
+	badBackup := `{"version":1,"data":`
+	if err := ioutil.WriteFile(backupFileName(sc.FlashcardsFile, 2), []byte(badBackup), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// copy encrypted with key which was removed from keyring can't be read either
+	oldKey, err := newTestKeyring(t, testKey("old", 1)).seal([]byte(`{"version":1,"data":{"1":{}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreadable := map[string]string{
+		backupFileName(sc.FlashcardsFile, 3):        badBackup,
+		sc.FlashcardsFile + ".broken-20210621-1000": "mitochondrium",
+		sc.FlashcardsFile + ".broken-20210622-1000": string(oldKey),
+	}
+	for _, name := range []string{sc.FlashcardsFile + ".broken-20210621-1000", sc.FlashcardsFile + ".broken-20210622-1000"} {
+		if err := ioutil.WriteFile(name, []byte(unreadable[name]), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broken := sc.FlashcardsFile + ".broken-20210620-1000"
+	if err := ioutil.WriteFile(broken, []byte(`{"version":1,"data":{"1":{"biologia":{"mitochondrium":"?"}}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteChat(1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{sc.FlashcardsFile, backupFileName(sc.FlashcardsFile, 1), backupFileName(sc.FlashcardsFile, 2), broken} {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(content), "mitochondrium") {
+			t.Errorf("%s still has data of deleted chat: %s", filepath.Base(name), content)
+		}
+	}
+	for name, content := range unreadable {
+		got, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(name), err)
+		}
+		if string(got) != content {
+			t.Errorf("%s was changed to %s", filepath.Base(name), got)
+		}
+	}
+}
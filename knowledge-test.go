@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -24,72 +26,108 @@ func generateTestFlashcards(fc flashcards, testRange int) flashcards {
 	return testFlashcards
 }
 
-// AskQuestions starts dialog in which bot sends definitions and user has to answer with correct term. It returns sum of correct answers.
-func (b *Bot) AskQuestions(fc flashcards, chatID chatid, chatLogger *log.Entry) (int, error) {
-	correctAnswers := 0
-	for term, definition := range fc {
-		answer, err := b.Dialog(chatID, "Co to jest? "+definition)
+// AskQuestions starts dialog in which bot sends definitions and user has to answer with correct term. Like in other dialogs, backCommand asks previous question again and new answer replaces the previous one. It returns sum of correct answers.
+func (b *Bot) AskQuestions(ctx context.Context, fc flashcards, chatID chatid, chatLogger *log.Entry) (int, error) {
+	lang := b.Language(chatID)
+	// terms are taken from map once, so they stay in random order, but going back asks the same question
+	terms := make([]string, 0, len(fc))
+	for term := range fc {
+		terms = append(terms, term)
+	}
+
+	correct := make([]bool, len(terms))
+	for i := 0; i < len(terms); {
+		term := terms[i]
+		answer, err := b.Dialog(ctx, chatID, lang.T("test.question", fc[term]))
 		if err != nil {
 			chatLogger.Info("Dialog ended unsuccessfully")
 			return 0, err
 		}
-		answer = strings.ToLower(answer)
-		if answer == term {
-			b.Output <- Msg{chatID, "Poprawna odpowiedz"}
-			correctAnswers++
+
+		if answer == backCommand {
+			if i > 0 {
+				i--
+			}
+			continue
+		}
+
+		correct[i] = strings.ToLower(answer) == term
+		if correct[i] {
+			b.Output <- Msg{chatID: chatID, text: lang.T("test.correct")}
 		} else {
-			b.Output <- Msg{chatID, "Bledna odpowiedz, poprawna to: " + strings.Title(term)}
+			b.Output <- Msg{chatID: chatID, text: lang.T("test.wrong", strings.Title(term))}
 		}
+		i++
 	}
 
+	correctAnswers := 0
+	for _, c := range correct {
+		if c {
+			correctAnswers++
+		}
+	}
 	return correctAnswers, nil
 }
 
 // KnowledgeTest starts dialog in which it asks for topic of flashcards and number of questions. Then it starts AskQuestions. After that it sends to user his score.
-func (b *Bot) KnowledgeTest(chatID chatid) {
+func (b *Bot) KnowledgeTest(ctx context.Context, chatID chatid) {
 	chatLogger := generateDialogLogger(chatID)
-	defer func() { b.InactiveInput <- chatID }()
-	fc := b.FlashcardsData
-
-	startMessage := "Test wiedzy z twoich fiszek. Bede podawal definicje roznych pojec, a ty odpowiedz nazwa pojecia. Na poczatek podaj temat, z ktorego chcesz zostac przepytany."
-
-	t, err := b.Dialog(chatID, startMessage)
+	topics, err := b.Store.Flashcards(chatID)
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	t = strings.ToLower(t)
-	top := topic(t)
-	//TODO make inline buttons
-	if _, ok := fc[chatID][top]; !ok {
-		b.Output <- Msg{chatID, "Temat nie istnieje"}
+		chatLogger.Error("Could not load flashcards")
 		return
 	}
 
-	fcTopic := fc[chatID][top]
-
-	askQuestionsNumber := "Podaj ilosc pytan, maksymalna ilosc dla tego tematu: " + strconv.Itoa(len(fcTopic))
-	testRangeAnswer, err := b.Dialog(chatID, askQuestionsNumber)
-	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
+	lang := b.Language(chatID)
+	startMessage := lang.T("test.intro")
 
-	testRange, err := strconv.Atoi(testRangeAnswer)
+	answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+		{
+			Name:   "topic",
+			Prompt: prompt(startMessage),
+			Options: func(dialogAnswers) []string {
+				names := []string{}
+				for top := range topics {
+					names = append(names, string(top))
+				}
+				sort.Strings(names)
+				return names
+			},
+			Parse: func(answer string, _ dialogAnswers) (interface{}, error) {
+				top := topic(strings.ToLower(answer))
+				if _, ok := topics[top]; !ok {
+					return nil, invalidAnswer(lang.T("topic.notFound"))
+				}
+				return top, nil
+			},
+		},
+		{
+			Name: "range",
+			Prompt: func(answers dialogAnswers) string {
+				return lang.T("test.askRange", len(topics[answers.Topic("topic")]))
+			},
+			Parse: func(answer string, _ dialogAnswers) (interface{}, error) {
+				testRange, err := strconv.Atoi(answer)
+				if err != nil {
+					return nil, invalidAnswer(lang.T("test.needNumber"))
+				}
+				return testRange, nil
+			},
+		},
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		b.Output <- Msg{chatID, "Musisz podac liczbe"}
 		return
 	}
 
-	testFlashcards := generateTestFlashcards(fcTopic, testRange)
+	testRange := answers.Int("range")
+	testFlashcards := generateTestFlashcards(topics[answers.Topic("topic")], testRange)
 
-	correctAnswers, err := b.AskQuestions(testFlashcards, chatID, chatLogger)
+	correctAnswers, err := b.AskQuestions(ctx, testFlashcards, chatID, chatLogger)
 	if err != nil {
 		return
 	}
 
-	result := "Odpowiedziales poprawnie na " + strconv.Itoa(correctAnswers) + " z " + testRangeAnswer
+	result := lang.T("test.result", correctAnswers, testRange)
 
-	b.Output <- Msg{chatID, result}
+	b.Output <- Msg{chatID: chatID, text: result}
 }
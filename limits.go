@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// dialogCost is how many tokens command opening dialog takes, because every dialog cancels the previous one and starts a goroutine.
+const dialogCost = 2
+
+// limitsConfig protects bot from users who send too many commands or too much data.
+// UserRate and ChatRate are how many commands per minute single user and chat can run, UserBurst and ChatBurst how many of them at once. Zero rate disables limit.
+// MaxFlashcards and MaxReminders limit number of flashcards and reminders in chat. MaxTextLength limits length of every answer in dialog. Zero disables limit.
+type limitsConfig struct {
+	UserRate      float64 `yaml:"userRate"`
+	UserBurst     int     `yaml:"userBurst"`
+	ChatRate      float64 `yaml:"chatRate"`
+	ChatBurst     int     `yaml:"chatBurst"`
+	MaxFlashcards int     `yaml:"maxFlashcards"`
+	MaxReminders  int     `yaml:"maxReminders"`
+	MaxTextLength int     `yaml:"maxTextLength"`
+}
+
+// throttle tells how long user has to wait before running command. Repeated is true if user was already told to wait, so bot doesn't answer spam with spam.
+type throttle struct {
+	wait     time.Duration
+	repeated bool
+}
+
+// tokenBucket has tokens which are taken by commands and refilled at constant rate. Warned is set when user was told to wait.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	warned bool
+}
+
+// refill adds tokens for time passed since bucket was used, up to burst. Rate is tokens per second.
+func (tb *tokenBucket) refill(now time.Time, rate float64, burst float64) {
+	tb.tokens = math.Min(burst, tb.tokens+now.Sub(tb.last).Seconds()*rate)
+	tb.last = now
+}
+
+// wait returns how long it takes to have cost tokens in bucket.
+func (tb *tokenBucket) wait(cost float64, rate float64) time.Duration {
+	if tb.tokens >= cost {
+		return 0
+	}
+	return time.Duration((cost - tb.tokens) / rate * float64(time.Second))
+}
+
+// bucketRate is refill rate and size of buckets.
+type bucketRate struct {
+	perSecond float64
+	burst     float64
+}
+
+// bucketLimiter limits commands of every user and every chat with token buckets. Command is run only if both user and chat have enough tokens.
+type bucketLimiter struct {
+	user bucketRate
+	chat bucketRate
+
+	mu        sync.Mutex
+	users     map[int64]*tokenBucket
+	chats     map[chatid]*tokenBucket
+	lastSweep time.Time
+}
+
+// newBucketLimiter creates limiter with rates from config.
+func newBucketLimiter(lc limitsConfig) *bucketLimiter {
+	return &bucketLimiter{
+		user:      bucketRate{lc.UserRate / 60, float64(lc.UserBurst)},
+		chat:      bucketRate{lc.ChatRate / 60, float64(lc.ChatBurst)},
+		users:     make(map[int64]*tokenBucket),
+		chats:     make(map[chatid]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes tokens for command from user's and chat's buckets. If any of them doesn't have enough tokens, nothing is taken and it returns how long to wait.
+func (bl *bucketLimiter) Allow(u Update, c *command) (throttle, bool) {
+	cost := 1.0
+	if c.opensDialog(u) {
+		cost = dialogCost
+	}
+
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	now := time.Now()
+	bl.sweep(now)
+
+	// cost can't be bigger than burst, otherwise command would never be allowed
+	type limited struct {
+		bucket *tokenBucket
+		rate   bucketRate
+		cost   float64
+	}
+	checked := []limited{}
+	// user ID is 0 if front-end doesn't know author
+	if bl.user.perSecond > 0 && u.UserID != 0 {
+		checked = append(checked, limited{bl.userBucket(u.UserID, now), bl.user, math.Min(cost, bl.user.burst)})
+	}
+	if bl.chat.perSecond > 0 {
+		checked = append(checked, limited{bl.chatBucket(u.ChatID, now), bl.chat, math.Min(cost, bl.chat.burst)})
+	}
+
+	t := throttle{repeated: true}
+	for _, l := range checked {
+		l.bucket.refill(now, l.rate.perSecond, l.rate.burst)
+		if wait := l.bucket.wait(l.cost, l.rate.perSecond); wait > 0 {
+			if wait > t.wait {
+				t.wait = wait
+			}
+			t.repeated = t.repeated && l.bucket.warned
+			l.bucket.warned = true
+		}
+	}
+	if t.wait > 0 {
+		return t, false
+	}
+
+	for _, l := range checked {
+		l.bucket.tokens -= l.cost
+		l.bucket.warned = false
+	}
+	return throttle{}, true
+}
+
+// userBucket returns bucket of user, new bucket is full.
+func (bl *bucketLimiter) userBucket(userID int64, now time.Time) *tokenBucket {
+	tb, ok := bl.users[userID]
+	if !ok {
+		tb = &tokenBucket{tokens: bl.user.burst, last: now}
+		bl.users[userID] = tb
+	}
+	return tb
+}
+
+// chatBucket returns bucket of chat, new bucket is full.
+func (bl *bucketLimiter) chatBucket(chatID chatid, now time.Time) *tokenBucket {
+	tb, ok := bl.chats[chatID]
+	if !ok {
+		tb = &tokenBucket{tokens: bl.chat.burst, last: now}
+		bl.chats[chatID] = tb
+	}
+	return tb
+}
+
+// sweep removes buckets which are full again once a minute, so limiter doesn't keep every user who ever wrote to bot.
+func (bl *bucketLimiter) sweep(now time.Time) {
+	if now.Sub(bl.lastSweep) < time.Minute {
+		return
+	}
+	bl.lastSweep = now
+
+	for id, tb := range bl.users {
+		if tb.tokens+now.Sub(tb.last).Seconds()*bl.user.perSecond >= bl.user.burst {
+			delete(bl.users, id)
+		}
+	}
+	for id, tb := range bl.chats {
+		if tb.tokens+now.Sub(tb.last).Seconds()*bl.chat.perSecond >= bl.chat.burst {
+			delete(bl.chats, id)
+		}
+	}
+}
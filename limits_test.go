@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name     string
+		tokens   float64
+		passed   time.Duration
+		rate     float64
+		burst    float64
+		cost     float64
+		want     float64
+		wantWait time.Duration
+	}{
+		{"full bucket", 5, time.Second, 1, 5, 1, 5, 0},
+		{"refilled", 0, 2 * time.Second, 0.5, 5, 1, 1, 0},
+		{"refilled up to burst", 1, time.Hour, 1, 3, 1, 3, 0},
+		{"empty", 0, 0, 0.5, 5, 1, 0, 2 * time.Second},
+		{"dialog needs more", 0.5, 0, 0.5, 5, 2, 0.5, 3 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := &tokenBucket{tokens: tt.tokens, last: start}
+			tb.refill(start.Add(tt.passed), tt.rate, tt.burst)
+			if tb.tokens != tt.want {
+				t.Errorf("tokens = %v, want %v", tb.tokens, tt.want)
+			}
+			if !tb.last.Equal(start.Add(tt.passed)) {
+				t.Error("last use was not updated")
+			}
+			if wait := tb.wait(tt.cost, tt.rate); wait != tt.wantWait {
+				t.Errorf("wait = %v, want %v", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestBucketLimiterAllow(t *testing.T) {
+	plain := &command{Name: "/fiszka"}
+	dialog := &command{Name: "/dodajfiszke", Dialog: true}
+	inline := &command{Name: "/jezyk", Dialog: true, Inline: func(u Update) bool { return u.Payload() != "" }}
+	type call struct {
+		update   Update
+		cmd      *command
+		allowed  bool
+		repeated bool
+	}
+	tests := []struct {
+		name   string
+		limits limitsConfig
+		calls  []call
+	}{
+		{
+			name:   "user burst",
+			limits: limitsConfig{UserRate: 1, UserBurst: 3},
+			calls: []call{
+				{Update{ChatID: 1, UserID: 1}, plain, true, false},
+				{Update{ChatID: 1, UserID: 1}, dialog, true, false},
+				{Update{ChatID: 1, UserID: 1}, plain, false, false},
+				{Update{ChatID: 1, UserID: 1}, plain, false, true},
+				{Update{ChatID: 2, UserID: 2}, dialog, true, false},
+			},
+		},
+		{
+			name:   "dialog costs more",
+			limits: limitsConfig{UserRate: 1, UserBurst: 3},
+			calls: []call{
+				{Update{ChatID: 1, UserID: 1}, dialog, true, false},
+				{Update{ChatID: 1, UserID: 1}, dialog, false, false},
+				{Update{ChatID: 1, UserID: 1}, plain, true, false},
+			},
+		},
+		{
+			name:   "inline dialog command costs like plain one",
+			limits: limitsConfig{UserRate: 1, UserBurst: 3},
+			calls: []call{
+				{Update{ChatID: 1, UserID: 1, Text: "/jezyk en"}, inline, true, false},
+				{Update{ChatID: 1, UserID: 1, Text: "/jezyk"}, inline, true, false},
+				{Update{ChatID: 1, UserID: 1, Text: "/jezyk en"}, inline, false, false},
+			},
+		},
+		{
+			name:   "cost is not bigger than burst",
+			limits: limitsConfig{UserRate: 1, UserBurst: 1},
+			calls: []call{
+				{Update{ChatID: 1, UserID: 1}, dialog, true, false},
+				{Update{ChatID: 1, UserID: 1}, dialog, false, false},
+			},
+		},
+		{
+			name:   "chat is shared by users",
+			limits: limitsConfig{UserRate: 1, UserBurst: 5, ChatRate: 1, ChatBurst: 2},
+			calls: []call{
+				{Update{ChatID: -1, UserID: 1}, plain, true, false},
+				{Update{ChatID: -1, UserID: 2}, plain, true, false},
+				{Update{ChatID: -1, UserID: 3}, plain, false, false},
+				{Update{ChatID: 1, UserID: 3}, plain, true, false},
+			},
+		},
+		{
+			name:   "unknown author is limited only by chat",
+			limits: limitsConfig{UserRate: 1, UserBurst: 1, ChatRate: 1, ChatBurst: 2},
+			calls: []call{
+				{Update{ChatID: 1}, plain, true, false},
+				{Update{ChatID: 1}, plain, true, false},
+				{Update{ChatID: 1}, plain, false, false},
+			},
+		},
+		{
+			name:   "disabled",
+			limits: limitsConfig{},
+			calls: []call{
+				{Update{ChatID: 1, UserID: 1}, dialog, true, false},
+				{Update{ChatID: 1, UserID: 1}, dialog, true, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bl := newBucketLimiter(tt.limits)
+			for i, c := range tt.calls {
+				th, ok := bl.Allow(c.update, c.cmd)
+				if ok != c.allowed {
+					t.Fatalf("call %d: allowed = %v, want %v", i, ok, c.allowed)
+				}
+				if !ok && th.wait <= 0 {
+					t.Errorf("call %d: throttled without wait", i)
+				}
+				if th.repeated != c.repeated {
+					t.Errorf("call %d: repeated = %v, want %v", i, th.repeated, c.repeated)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketLimiterSweep(t *testing.T) {
+	bl := newBucketLimiter(limitsConfig{UserRate: 60, UserBurst: 5, ChatRate: 60, ChatBurst: 5})
+	now := time.Now()
+	bl.users[1] = &tokenBucket{tokens: 5, last: now}
+	bl.users[2] = &tokenBucket{tokens: 0, last: now}
+	bl.chats[1] = &tokenBucket{tokens: 0, last: now.Add(-time.Hour)}
+
+	bl.sweep(now)
+	if len(bl.users) != 2 {
+		t.Fatal("buckets were swept before a minute passed")
+	}
+
+	bl.lastSweep = now.Add(-2 * time.Minute)
+	bl.sweep(now)
+	if _, ok := bl.users[1]; ok {
+		t.Error("full user bucket was kept")
+	}
+	if _, ok := bl.users[2]; !ok {
+		t.Error("empty user bucket was removed")
+	}
+	if _, ok := bl.chats[1]; ok {
+		t.Error("refilled chat bucket was kept")
+	}
+}
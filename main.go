@@ -1,10 +1,157 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// openStore opens storage backend chosen in config. Writes to store are measured for metrics.
+func openStore(sc storageConfig) (Store, error) {
+	var s Store
+	var err error
+	if sc.Backend == "sqlite" {
+		s, err = newSQLStore(sc.SQLitePath, sc.keys)
+	} else {
+		s, err = newJSONStore(sc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return instrumentStore(s, sc.Backend), nil
+}
+
+// openStoreReadOnly loads data of configured backend like validate does, without creating, migrating or restoring anything, so data can be read while bot is running. Backups are not read. Data which can't be read or database with older schema is an error, not an empty store.
+func openStoreReadOnly(sc storageConfig) (Store, error) {
+	var s Store
+	var problems []string
+	var err error
+	if sc.Backend == "sqlite" {
+		if _, err := os.Stat(sc.SQLitePath); err != nil {
+			return nil, err
+		}
+		var db *sqlStore
+		if db, problems, err = checkSQLFile(sc.SQLitePath, sc.keys); db != nil {
+			s = db
+		}
+	} else {
+		sc.Backups = 0
+		var js *jsonStore
+		if js, problems, err = checkJSONFiles(sc); js != nil {
+			s = js
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(problems) > 0 {
+		if s != nil {
+			s.Close()
+		}
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	if s == nil {
+		return nil, errors.New("database schema is older than bot, run migrate first")
+	}
+	return s, nil
+}
+
+// main runs subcommand given as the first argument, by default it runs telegram bot. The rest of arguments are flags, see loadConfig, and arguments of subcommand.
 func main() {
-	assistant := NewBot(os.Getenv("telegramBot"), "dev")
+	args := os.Args[1:]
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	command, ok := findCLICommand(name)
+	if !ok {
+		printUsage(os.Stderr)
+		if name == "help" {
+			return
+		}
+		os.Exit(2)
+	}
+
+	cfg, rest, err := loadConfig(args, command.Telegram)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if len(rest) != len(command.Args) {
+		fmt.Fprintln(os.Stderr, "Usage: student-assistant-bot "+strings.TrimSpace(name+" [flags] "+strings.Join(command.Args, " ")))
+		os.Exit(2)
+	}
+	if command.Bot {
+		setupLogging(cfg.Env, cfg.LogFile)
+	}
+
+	if err := command.Run(cfg, rest); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// runBot runs bot until it gets SIGINT or SIGTERM, or until it can't receive updates anymore, then it returns error. Repl means bot talks with user in terminal instead of telegram.
+func runBot(cfg *config, repl bool) error {
+	var messenger Messenger
+	if repl {
+		// in terminal only warnings and errors are logged, so they don't hide bot's replies
+		log.SetLevel(log.WarnLevel)
+		messenger = newConsoleMessenger(os.Stdin, os.Stdout)
+		// terminal has no rate limits
+		cfg.Outbound.GlobalRate, cfg.Outbound.ChatInterval, cfg.Outbound.GroupInterval = 0, 0, 0
+	} else {
+		tm, err := newTelegramMessenger(cfg.Token, telegramPoller(cfg.Telegram))
+		if err != nil {
+			return errors.New("could not create bot")
+		}
+		messenger = tm
+	}
+
+	store, err := openStore(cfg.Storage)
+	if err != nil {
+		return errors.New("could not load data: " + err.Error())
+	}
+
+	assistant := NewBot(messenger, store, cfg)
+	if cm, ok := messenger.(*consoleMessenger); ok {
+		cm.Language = assistant.Language
+	}
+	metricsServer := assistant.StartMetricsServer(cfg.Metrics)
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		log.Warn("Received " + sig.String() + ", shutting down")
+		assistant.Stop()
+
+		// second signal stops bot immediately
+		sig = <-signals
+		log.Warn("Received " + sig.String() + " again, exiting without cleanup")
+		os.Exit(1)
+	}()
+
 	assistant.Run()
+	assistant.Shutdown(cfg.ShutdownTimeout)
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+
+	// bot stopped by itself, e.g. webhook server failed, so process has to exit with error
+	if tm, ok := messenger.(*telegramMessenger); ok {
+		if err := tm.Err(); err != nil {
+			return errors.New("bot stopped receiving updates: " + err.Error())
+		}
+	}
+	return nil
 }
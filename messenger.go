@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Update is a text message received from any front-end.
+// ChatID is a chat where message was written.
+// UserID is an author of the message, it can be 0 if front-end doesn't know it.
+// Text is full message text, including command and its payload.
+type Update struct {
+	ChatID chatid
+	UserID int64
+	Text   string
+}
+
+// Messenger is a transport used by bot to talk with users. Bot core uses only this interface, so it doesn't depend on any specific chat api.
+// Send and SendKeyboard get format of text, front-ends which can't show formatting send it without HTML tags.
+// SetCommands shows users list of available commands, if front-end supports it.
+// Start listens for incoming updates and passes them to handler, it blocks until Stop is called.
+// Health reports error if messenger can't receive updates.
+// ChatAdmins returns user IDs of group admins.
+type Messenger interface {
+	Send(chat chatid, text string, format textFormat) error
+	SendKeyboard(chat chatid, text string, format textFormat, options []string) error
+	SendDocument(chat chatid, fileName string, data []byte, caption string) error
+	SetCommands(commands []commandInfo) error
+	Start(handler func(Update))
+	Stop()
+	Health() error
+	ChatAdmins(chat chatid) ([]int64, error)
+}
+
+// Command returns command name if update is a command, e.g. "/fiszka" for "/fiszka kot" or "/fiszka@bot kot". Otherwise it returns empty string.
+func (u Update) Command() string {
+	if !strings.HasPrefix(u.Text, "/") {
+		return ""
+	}
+
+	command := strings.Fields(u.Text)[0]
+	if i := strings.Index(command, "@"); i != -1 {
+		command = command[:i]
+	}
+	return command
+}
+
+// Payload returns text written after command, e.g. "kot" for "/fiszka kot".
+func (u Update) Payload() string {
+	if u.Command() == "" {
+		return ""
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(u.Text), " ", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// temporaryError is returned by Messenger when message could not be sent now, but sending it later can succeed, e.g. after rate limit or network error.
+// RetryAfter is how long server asked to wait, 0 if it didn't say.
+type temporaryError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *temporaryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *temporaryError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const metricsNamespace = "student_assistant_bot"
+
+// metricsConfig stores settings of http server with metrics and health endpoint.
+// Listen is address of the server, e.g. ":9090", empty disables the server.
+type metricsConfig struct {
+	Listen string `yaml:"listen"`
+}
+
+var (
+	commandsHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_handled_total",
+		Help:      "Number of handled commands, without refused and rate limited ones.",
+	}, []string{"command"})
+	commandsThrottled = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_throttled_total",
+		Help:      "Number of commands dropped by rate limiter.",
+	})
+	dialogTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dialog_timeouts_total",
+		Help:      "Number of dialogs in which user did not answer in time.",
+	})
+	messagesSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_sent_total",
+		Help:      "Number of messages delivered to chats.",
+	})
+	messagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_failed_total",
+		Help:      "Number of failed attempts of sending message, by what happened to the message: retried or dropped.",
+	}, []string{"result"})
+	pendingReminders = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_reminders",
+		Help:      "Number of reminders waiting to be sent.",
+	})
+	storageWriteDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "storage_write_duration_seconds",
+		Help:      "Time of writing changes to storage.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"backend", "operation"})
+)
+
+// newMetricsRegistry creates registry with all bot metrics. Number of active dialogs is read from bot's sessions when metrics are scraped.
+func newMetricsRegistry(b *Bot) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		commandsHandled,
+		commandsThrottled,
+		dialogTimeouts,
+		messagesSent,
+		messagesFailed,
+		pendingReminders,
+		storageWriteDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_dialogs",
+			Help:      "Number of dialogs waiting for user's answer.",
+		}, func() float64 {
+			return float64(b.Sessions.Active())
+		}),
+	)
+	return registry
+}
+
+// metricsMiddleware counts handled commands.
+func metricsMiddleware(c *command, next commandHandler) commandHandler {
+	return func(ctx context.Context, u Update) {
+		commandsHandled.WithLabelValues(c.Name).Inc()
+		next(ctx, u)
+	}
+}
+
+// healthStatus is a body of health endpoint response.
+type healthStatus struct {
+	Status  string `json:"status"`
+	Poller  string `json:"poller"`
+	Storage string `json:"storage"`
+}
+
+// Health checks if bot receives updates and if storage works.
+func (b *Bot) Health() healthStatus {
+	hs := healthStatus{Status: "ok", Poller: "ok", Storage: "ok"}
+	if err := b.messenger.Health(); err != nil {
+		hs.Status, hs.Poller = "fail", err.Error()
+	}
+	if err := b.Store.Ping(); err != nil {
+		hs.Status, hs.Storage = "fail", err.Error()
+	}
+	return hs
+}
+
+// healthHandler responds with bot's health, status code is 503 if anything fails, so it can be used by load balancers and orchestrators.
+func (b *Bot) healthHandler(rw http.ResponseWriter, r *http.Request) {
+	hs := b.Health()
+	rw.Header().Set("Content-Type", "application/json")
+	if hs.Status != "ok" {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(rw).Encode(hs)
+}
+
+// StartMetricsServer starts http server with prometheus metrics on /metrics and health on /healthz. It returns nil if server is disabled in config.
+func (b *Bot) StartMetricsServer(mc metricsConfig) *http.Server {
+	if mc.Listen == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(newMetricsRegistry(b), promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", b.healthHandler)
+	server := &http.Server{Addr: mc.Listen, Handler: mux}
+
+	metricsLogger := log.WithField("listen", mc.Listen)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			metricsLogger.Error("Metrics server failed: " + err.Error())
+		}
+	}()
+	metricsLogger.Info("Metrics server started")
+	return server
+}
+
+// metricsStore is a Store which measures time of every write to wrapped store.
+type metricsStore struct {
+	Store
+	backend string
+}
+
+// instrumentStore wraps store, so its writes are measured.
+func instrumentStore(s Store, backend string) Store {
+	return &metricsStore{Store: s, backend: backend}
+}
+
+// observe records time of operation which started at start.
+func (s *metricsStore) observe(operation string, start time.Time) {
+	storageWriteDuration.WithLabelValues(s.backend, operation).Observe(time.Since(start).Seconds())
+}
+
+func (s *metricsStore) PutFlashcard(chatID chatid, top topic, term string, definition string) error {
+	defer s.observe("putFlashcard", time.Now())
+	return s.Store.PutFlashcard(chatID, top, term, definition)
+}
+
+func (s *metricsStore) DeleteFlashcard(chatID chatid, top topic, term string) error {
+	defer s.observe("deleteFlashcard", time.Now())
+	return s.Store.DeleteFlashcard(chatID, top, term)
+}
+
+func (s *metricsStore) AddReminder(chatID chatid, r Reminder) error {
+	defer s.observe("addReminder", time.Now())
+	return s.Store.AddReminder(chatID, r)
+}
+
+func (s *metricsStore) DeleteReminder(chatID chatid, r Reminder) error {
+	defer s.observe("deleteReminder", time.Now())
+	return s.Store.DeleteReminder(chatID, r)
+}
+
+func (s *metricsStore) SetSchoolDay(chatID chatid, wd Weekday, day schoolDay) error {
+	defer s.observe("setSchoolDay", time.Now())
+	return s.Store.SetSchoolDay(chatID, wd, day)
+}
+
+func (s *metricsStore) DeleteSchedule(chatID chatid) error {
+	defer s.observe("deleteSchedule", time.Now())
+	return s.Store.DeleteSchedule(chatID)
+}
+
+func (s *metricsStore) SetLanguage(chatID chatid, lang language) error {
+	defer s.observe("setLanguage", time.Now())
+	return s.Store.SetLanguage(chatID, lang)
+}
+
+func (s *metricsStore) DeleteChat(chatID chatid) error {
+	defer s.observe("deleteChat", time.Now())
+	return s.Store.DeleteChat(chatID)
+}
+
+func (s *metricsStore) AddPendingMessage(m pendingMessage) error {
+	defer s.observe("addPendingMessage", time.Now())
+	return s.Store.AddPendingMessage(m)
+}
+
+func (s *metricsStore) DeletePendingMessage(id string) error {
+	defer s.observe("deletePendingMessage", time.Now())
+	return s.Store.DeletePendingMessage(id)
+}
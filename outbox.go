@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const outboxFileName = "outbox.json"
+
+// maxRetryDelay is the longest time between attempts of sending message, unless server asks for longer.
+const maxRetryDelay = time.Minute
+
+// outgoing is a message waiting in outbound queue.
+// Attempts is how many times sending already failed.
+// NotBefore is time of the next attempt after failure.
+// Persistent is set for every part of message kept in store, not only for the last one, which carries its pending ID.
+type outgoing struct {
+	msg        Msg
+	attempts   int
+	notBefore  time.Time
+	persistent bool
+}
+
+// outboundQueue keeps messages waiting for sending separately for every chat, so messages to one chat keep their order and chat which is rate limited doesn't stop others.
+// Order keeps chats with waiting messages in order in which they got them, so every chat gets its turn.
+// LastSent is time of the last attempt of sending to chat, pausedUntil is set when telegram asks to wait.
+type outboundQueue struct {
+	limits      outboundConfig
+	chats       map[chatid][]*outgoing
+	order       []chatid
+	lastSent    map[chatid]time.Time
+	lastGlobal  time.Time
+	pausedUntil time.Time
+}
+
+// newOutboundQueue creates empty queue with given limits.
+func newOutboundQueue(limits outboundConfig) *outboundQueue {
+	return &outboundQueue{
+		limits:   limits,
+		chats:    make(map[chatid][]*outgoing),
+		lastSent: make(map[chatid]time.Time),
+	}
+}
+
+// empty reports if there are no waiting messages.
+func (q *outboundQueue) empty() bool {
+	return len(q.order) == 0
+}
+
+// push adds message at the end of its chat's queue. Message too long for telegram is split into parts, which are queued one after another, so they are sent in order.
+func (q *outboundQueue) push(m Msg) {
+	if len(q.chats[m.chatID]) == 0 {
+		q.order = append(q.order, m.chatID)
+	}
+	for _, part := range splitMsg(m) {
+		q.chats[m.chatID] = append(q.chats[m.chatID], &outgoing{msg: part, persistent: m.pendingID != ""})
+	}
+}
+
+// dropPersistent removes messages kept in store from queue and returns how many were removed. They are sent again after restart.
+func (q *outboundQueue) dropPersistent() int {
+	dropped := 0
+	order := q.order[:0]
+	for _, chatID := range q.order {
+		kept := q.chats[chatID][:0]
+		for _, o := range q.chats[chatID] {
+			if o.persistent {
+				dropped++
+				continue
+			}
+			kept = append(kept, o)
+		}
+		if len(kept) == 0 {
+			delete(q.chats, chatID)
+			continue
+		}
+		q.chats[chatID] = kept
+		order = append(order, chatID)
+	}
+	q.order = order
+	return dropped
+}
+
+// chatInterval returns minimal time between messages to chat. Group chats have negative IDs and lower limit.
+func (q *outboundQueue) chatInterval(chatID chatid) time.Duration {
+	if chatID < 0 {
+		return q.limits.GroupInterval
+	}
+	return q.limits.ChatInterval
+}
+
+// globalInterval returns minimal time between any two messages.
+func (q *outboundQueue) globalInterval() time.Duration {
+	if q.limits.GlobalRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / q.limits.GlobalRate)
+}
+
+// latest returns the latest of given times.
+func latest(times ...time.Time) time.Time {
+	var l time.Time
+	for _, t := range times {
+		if t.After(l) {
+			l = t
+		}
+	}
+	return l
+}
+
+// next returns the first message which can be sent at now. If no message can be sent yet, it returns how long to wait for one. If queue is empty, returned duration is negative.
+func (q *outboundQueue) next(now time.Time) (*outgoing, time.Duration) {
+	if q.empty() {
+		return nil, -1
+	}
+
+	global := latest(q.lastGlobal.Add(q.globalInterval()), q.pausedUntil)
+	var earliest time.Time
+	for _, chatID := range q.order {
+		o := q.chats[chatID][0]
+		ready := latest(global, o.notBefore, q.lastSent[chatID].Add(q.chatInterval(chatID)))
+		if !ready.After(now) {
+			return o, 0
+		}
+		if earliest.IsZero() || ready.Before(earliest) {
+			earliest = ready
+		}
+	}
+	return nil, earliest.Sub(now)
+}
+
+// attempted records that message was just sent to its chat, so limits are counted from now.
+func (q *outboundQueue) attempted(o *outgoing, now time.Time) {
+	q.lastSent[o.msg.chatID] = now
+	q.lastGlobal = now
+
+	// chats which didn't get anything for longer than any interval don't need their last time
+	for chatID, last := range q.lastSent {
+		if len(q.chats[chatID]) == 0 && now.Sub(last) > q.limits.ChatInterval && now.Sub(last) > q.limits.GroupInterval {
+			delete(q.lastSent, chatID)
+		}
+	}
+}
+
+// remove deletes message from the front of its chat's queue.
+func (q *outboundQueue) remove(o *outgoing) {
+	chatID := o.msg.chatID
+	q.chats[chatID] = q.chats[chatID][1:]
+	if len(q.chats[chatID]) > 0 {
+		return
+	}
+
+	delete(q.chats, chatID)
+	for i, c := range q.order {
+		if c == chatID {
+			q.order = append(q.order[:i:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// retryDelay returns time to wait before next attempt. Server's retry after is always honoured, otherwise delay doubles with every attempt.
+func retryDelay(attempts int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	delay := time.Second << uint(attempts)
+	if delay <= 0 || delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// HandleOutput takes messages from Output channel and sends them to desired chats within rate limits. Messages which failed because of temporary error are sent again later.
+// After shutdown starts, messages kept in store are dropped from queue, they are sent after restart. Other waiting messages are sent until drainUntil, what is left then is lost.
+func (b *Bot) HandleOutput() {
+	defer close(b.outputDone)
+
+	q := newOutboundQueue(b.OutboundLimits)
+	stop := b.outputStop
+	for {
+		if stop == nil && !time.Now().Before(b.drainUntil) {
+			if !q.empty() {
+				log.WithField("chats", len(q.order)).Error("Could not send all messages before shutdown, dropping them")
+			}
+			return
+		}
+
+		o, wait := q.next(time.Now())
+		if o != nil {
+			b.deliver(q, o)
+			continue
+		}
+
+		if stop == nil && q.empty() {
+			select {
+			case m := <-b.Output:
+				if m.pendingID == "" {
+					q.push(m)
+				}
+				continue
+			default:
+				return
+			}
+		}
+
+		if stop == nil && wait > time.Until(b.drainUntil) {
+			wait = time.Until(b.drainUntil)
+		}
+		var retry <-chan time.Time
+		if wait >= 0 {
+			retry = time.After(wait)
+		}
+		select {
+		case m := <-b.Output:
+			// after stop message kept in store is left for restart
+			if stop == nil && m.pendingID != "" {
+				continue
+			}
+			q.push(m)
+		case <-retry:
+		case <-stop:
+			// nil channel is never ready, so stop is handled only once
+			stop = nil
+			if dropped := q.dropPersistent(); dropped > 0 {
+				log.WithField("messages", dropped).Info("Leaving undelivered messages in store until restart")
+			}
+		}
+	}
+}
+
+// deliver sends message and decides what to do if it fails. Messages kept in store are retried until they are delivered or fail with permanent error, others are dropped after MaxAttempts.
+func (b *Bot) deliver(q *outboundQueue, o *outgoing) {
+	err := b.send(o.msg)
+	now := time.Now()
+	q.attempted(o, now)
+
+	if err == nil {
+		messagesSent.Inc()
+		q.remove(o)
+		b.deletePending(o.msg)
+		return
+	}
+
+	o.attempts++
+	msgLogger := log.WithFields(log.Fields{
+		"chat":     o.msg.chatID,
+		"attempts": o.attempts,
+		"error":    err.Error(),
+	})
+
+	var te *temporaryError
+	if errors.As(err, &te) && (o.msg.pendingID != "" || o.attempts < b.OutboundLimits.MaxAttempts) {
+		delay := retryDelay(o.attempts-1, te.retryAfter)
+		o.notBefore = now.Add(delay)
+		if te.retryAfter > 0 {
+			// flood limit is counted for whole bot, so other chats have to wait too
+			q.pausedUntil = o.notBefore
+		}
+		messagesFailed.WithLabelValues("retried").Inc()
+		msgLogger.WithField("retryIn", delay.String()).Warn("Could not send message, will try again")
+		return
+	}
+
+	messagesFailed.WithLabelValues("dropped").Inc()
+	msgLogger.WithField("message", o.msg.text).Error("Could not send message, dropping it")
+	q.remove(o)
+	b.deletePending(o.msg)
+	if o.msg.document != nil {
+		// user waits for the file, so they are told that it won't come
+		q.push(Msg{chatID: o.msg.chatID, text: b.T(o.msg.chatID, "error.tryLater")})
+	}
+}
+
+// send sends message with or without keyboard, or document with caption.
+func (b *Bot) send(m Msg) error {
+	if m.document != nil {
+		return b.messenger.SendDocument(m.chatID, m.document.fileName, m.document.data, m.text)
+	}
+	if len(m.keyboard) > 0 {
+		return b.messenger.SendKeyboard(m.chatID, m.text, m.format, m.keyboard)
+	}
+	return b.messenger.Send(m.chatID, m.text, m.format)
+}
+
+// newPendingID returns random ID of pending message.
+func newPendingID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(buf)
+}
+
+// SendPersistent saves message in store before sending it, so it is sent after restart if bot stops before delivering it. It is used for messages which can't be lost, like reminders.
+func (b *Bot) SendPersistent(chatID chatid, text string) {
+	m := Msg{chatID: chatID, text: text}
+	pm := pendingMessage{ID: newPendingID(), ChatID: chatID, Text: text, Created: time.Now()}
+	if err := b.Store.AddPendingMessage(pm); err != nil {
+		generateDialogLogger(chatID).Error("Could not save pending message, sending it anyway")
+	} else {
+		m.pendingID = pm.ID
+	}
+	b.Output <- m
+}
+
+// deletePending deletes message from store after it was delivered or can't be ever delivered.
+func (b *Bot) deletePending(m Msg) {
+	if m.pendingID == "" {
+		return
+	}
+	if err := b.Store.DeletePendingMessage(m.pendingID); err != nil {
+		generateDialogLogger(m.chatID).Error("Could not delete pending message, it may be sent again after restart")
+	}
+}
+
+// ResendPending sends messages which were not delivered before bot stopped.
+func (b *Bot) ResendPending() {
+	messages, err := b.Store.PendingMessages()
+	if err != nil {
+		log.Error("Could not load pending messages")
+		return
+	}
+	for _, pm := range messages {
+		b.Output <- Msg{chatID: pm.ChatID, text: pm.Text, pendingID: pm.ID}
+	}
+	if len(messages) > 0 {
+		log.WithField("messages", len(messages)).Info("Resending undelivered messages")
+	}
+}
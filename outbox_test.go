@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts   int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{0, 0, time.Second},
+		{1, 0, 2 * time.Second},
+		{3, 0, 8 * time.Second},
+		{6, 0, maxRetryDelay},
+		{100, 0, maxRetryDelay},
+		{0, 30 * time.Second, 30 * time.Second},
+		{10, 5 * time.Minute, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts, tt.retryAfter); got != tt.want {
+			t.Errorf("retryDelay(%d, %v) = %v, want %v", tt.attempts, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestOutboundQueue(t *testing.T) {
+	now := time.Now()
+	q := newOutboundQueue(outboundConfig{ChatInterval: time.Second, GroupInterval: 3 * time.Second})
+	if o, wait := q.next(now); o != nil || wait >= 0 {
+		t.Fatal("empty queue returned message")
+	}
+
+	q.push(Msg{chatID: 1, text: "a1"})
+	q.push(Msg{chatID: 1, text: "a2"})
+	q.push(Msg{chatID: -2, text: "b1"})
+	q.push(Msg{chatID: -2, text: "b2"})
+
+	// every step sends the first ready message, or waits for it
+	steps := []struct {
+		at   time.Duration
+		text string
+		wait time.Duration
+	}{
+		{0, "a1", 0},
+		{0, "b1", 0},
+		{0, "", time.Second},
+		{time.Second, "a2", 0},
+		{2 * time.Second, "", time.Second},
+		{3 * time.Second, "b2", 0},
+	}
+	for i, s := range steps {
+		at := now.Add(s.at)
+		o, wait := q.next(at)
+		if s.text == "" {
+			if o != nil {
+				t.Fatalf("step %d: sent %q, want wait", i, o.msg.text)
+			}
+			if wait != s.wait {
+				t.Fatalf("step %d: wait = %v, want %v", i, wait, s.wait)
+			}
+			continue
+		}
+		if o == nil || o.msg.text != s.text {
+			t.Fatalf("step %d: got %v, want %q", i, o, s.text)
+		}
+		q.attempted(o, at)
+		q.remove(o)
+	}
+	if !q.empty() {
+		t.Error("queue is not empty")
+	}
+}
+
+func TestOutboundQueueSplitsLongMessages(t *testing.T) {
+	q := newOutboundQueue(outboundConfig{})
+	q.push(Msg{chatID: 1, text: strings.Repeat("a", 2*maxMessageLength)})
+	if len(q.chats[1]) != 2 || len(q.order) != 1 {
+		t.Errorf("queue has %d messages in %d chats, want 2 in 1", len(q.chats[1]), len(q.order))
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	temporary := &temporaryError{err: errors.New("network error")}
+	flood := &temporaryError{err: errors.New("too many requests"), retryAfter: 30 * time.Second}
+	permanent := errors.New("chat not found")
+
+	tests := []struct {
+		name       string
+		err        error
+		attempts   int
+		persistent bool
+		sent       bool
+		kept       bool
+		retryIn    time.Duration
+		paused     bool
+	}{
+		{name: "sent", sent: true},
+		{name: "sent persistent", persistent: true, sent: true},
+		{name: "temporary error", err: temporary, kept: true, retryIn: time.Second},
+		{name: "temporary error again", err: temporary, attempts: 2, kept: true, retryIn: 4 * time.Second},
+		{name: "flood limit", err: flood, kept: true, retryIn: 30 * time.Second, paused: true},
+		{name: "too many attempts", err: temporary, attempts: 4},
+		{name: "persistent is retried", err: temporary, attempts: 10, persistent: true, kept: true, retryIn: maxRetryDelay},
+		{name: "permanent error", err: permanent},
+		{name: "persistent with permanent error", err: permanent, persistent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTestMessenger()
+			tm.fail = func(Msg) error { return tt.err }
+			b := &Bot{messenger: tm, Store: newTestStore(t), OutboundLimits: outboundConfig{MaxAttempts: 5}}
+
+			m := Msg{chatID: 1, text: "kolokwium"}
+			if tt.persistent {
+				pm := pendingMessage{ID: "1", ChatID: 1, Text: m.text}
+				if err := b.Store.AddPendingMessage(pm); err != nil {
+					t.Fatal(err)
+				}
+				m.pendingID = pm.ID
+			}
+			q := newOutboundQueue(b.OutboundLimits)
+			q.push(m)
+			o, _ := q.next(time.Now())
+			o.attempts = tt.attempts
+
+			before := time.Now()
+			b.deliver(q, o)
+
+			if sent := len(tm.sent) == 1; sent != tt.sent {
+				t.Errorf("sent = %v, want %v", sent, tt.sent)
+			}
+			if kept := !q.empty(); kept != tt.kept {
+				t.Fatalf("kept in queue = %v, want %v", kept, tt.kept)
+			}
+			if tt.kept {
+				if o.attempts != tt.attempts+1 {
+					t.Errorf("attempts = %d, want %d", o.attempts, tt.attempts+1)
+				}
+				if retryIn := o.notBefore.Sub(before); retryIn < tt.retryIn || retryIn > tt.retryIn+time.Second {
+					t.Errorf("retry in %v, want %v", retryIn, tt.retryIn)
+				}
+				if paused := q.pausedUntil.After(before); paused != tt.paused {
+					t.Errorf("paused = %v, want %v", paused, tt.paused)
+				}
+			}
+
+			pending, err := b.Store.PendingMessages()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored := len(pending) == 1; stored != (tt.persistent && tt.kept) {
+				t.Errorf("stored = %v, want %v", stored, tt.persistent && tt.kept)
+			}
+		})
+	}
+}
+
+func TestSendPersistent(t *testing.T) {
+	b, tm := newTestBot(t)
+
+	b.SendPersistent(1, "kolokwium")
+	expectSent(t, tm, "kolokwium")
+	// message is deleted from store right after it is sent
+	for i := 0; ; i++ {
+		pending, err := b.Store.PendingMessages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("delivered message is still in store: %v", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResendPending(t *testing.T) {
+	b, tm := newTestBot(t)
+	for _, pm := range []pendingMessage{{ID: "1", ChatID: 1, Text: "a"}, {ID: "2", ChatID: 1, Text: "b"}} {
+		if err := b.Store.AddPendingMessage(pm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.ResendPending()
+	expectSent(t, tm, "a", "b")
+}
+
+// closeRecorder is a store which remembers if it was closed.
+type closeRecorder struct {
+	Store
+	closed chan struct{}
+}
+
+func (c *closeRecorder) Close() error {
+	close(c.closed)
+	return c.Store.Close()
+}
+
+// newShutdownBot creates bot for testing shutdown, which is called by test itself.
+func newShutdownBot(t *testing.T, fail func(Msg) error) (*Bot, *closeRecorder) {
+	cfg := defaultConfig()
+	cfg.gradeScale = defaultGradeScale()
+	cfg.Outbound = outboundConfig{MaxAttempts: 100}
+
+	tm := newTestMessenger()
+	tm.fail = fail
+	store := &closeRecorder{Store: newTestStore(t), closed: make(chan struct{})}
+	b := NewBot(tm, store, cfg)
+	go b.HandleOutput()
+	return b, store
+}
+
+func TestShutdownLeavesPersistentInStore(t *testing.T) {
+	b, store := newShutdownBot(t, func(Msg) error {
+		return &temporaryError{err: errors.New("network error")}
+	})
+	b.SendPersistent(1, "kolokwium")
+	b.Output <- Msg{chatID: 2, text: "hej"}
+
+	start := time.Now()
+	b.Shutdown(500 * time.Millisecond)
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("shutdown took %v", took)
+	}
+	select {
+	case <-b.outputDone:
+	case <-time.After(time.Second):
+		t.Fatal("output is still handled after shutdown")
+	}
+	select {
+	case <-store.closed:
+	default:
+		t.Error("store was not closed")
+	}
+
+	pending, err := b.Store.PendingMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Text != "kolokwium" {
+		t.Errorf("pending messages = %v, want the undelivered one", pending)
+	}
+}
+
+func TestShutdownClosesStoreAfterTimeout(t *testing.T) {
+	blocked := make(chan struct{})
+	t.Cleanup(func() { close(blocked) })
+	b, store := newShutdownBot(t, func(Msg) error {
+		<-blocked
+		return nil
+	})
+	b.Output <- Msg{chatID: 1, text: "hej"}
+
+	b.Shutdown(100 * time.Millisecond)
+	select {
+	case <-store.closed:
+	default:
+		t.Error("store was not closed after timeout")
+	}
+}
+
+func TestDeliverDocument(t *testing.T) {
+	tm := newTestMessenger()
+	tm.fail = func(m Msg) error {
+		if m.text == "dane" {
+			return errors.New("file is too big")
+		}
+		return nil
+	}
+	b := &Bot{messenger: tm, Store: newTestStore(t), OutboundLimits: outboundConfig{MaxAttempts: 5}}
+
+	q := newOutboundQueue(b.OutboundLimits)
+	q.push(Msg{chatID: 1, text: "dane", document: &document{fileName: "chat-1.json", data: []byte("{}")}})
+	o, _ := q.next(time.Now())
+	b.deliver(q, o)
+
+	// user is told that file won't come
+	o, _ = q.next(time.Now())
+	if o == nil || o.msg.text != b.T(1, "error.tryLater") || o.msg.document != nil {
+		t.Fatalf("queued %v after document was dropped, want error message", o)
+	}
+}
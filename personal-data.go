@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// chatStats counts data of a single chat.
+type chatStats struct {
+	Topics          int `json:"topics"`
+	Flashcards      int `json:"flashcards"`
+	Reminders       int `json:"reminders"`
+	Classes         int `json:"classes"`
+	PendingMessages int `json:"pendingMessages"`
+}
+
+// chatData keeps all data which bot stores for a single chat. It is sent as json file to users who ask for their data and to admins for support cases.
+type chatData struct {
+	ChatID          chatid               `json:"chatId"`
+	Exported        time.Time            `json:"exported"`
+	Language        language             `json:"language,omitempty"`
+	Flashcards      map[topic]flashcards `json:"flashcards"`
+	Reminders       []Reminder           `json:"reminders"`
+	Schedule        schedule             `json:"schedule"`
+	PendingMessages []pendingMessage     `json:"pendingMessages"`
+	Stats           chatStats            `json:"stats"`
+}
+
+// ChatData collects all data of given chat from store.
+func (b *Bot) ChatData(chatID chatid) (chatData, error) {
+	cd := chatData{ChatID: chatID, Exported: time.Now(), PendingMessages: []pendingMessage{}}
+	var err error
+	if cd.Language, err = b.Store.Language(chatID); err != nil {
+		return cd, err
+	}
+	if cd.Flashcards, err = b.Store.Flashcards(chatID); err != nil {
+		return cd, err
+	}
+	if cd.Reminders, err = b.Store.Reminders(chatID); err != nil {
+		return cd, err
+	}
+	if cd.Schedule, err = b.Store.Schedule(chatID); err != nil {
+		return cd, err
+	}
+	pending, err := b.Store.PendingMessages()
+	if err != nil {
+		return cd, err
+	}
+	for _, m := range pending {
+		if m.ChatID == chatID {
+			cd.PendingMessages = append(cd.PendingMessages, m)
+		}
+	}
+
+	cd.Stats = chatStats{
+		Topics:          len(cd.Flashcards),
+		Flashcards:      countFlashcards(cd.Flashcards),
+		Reminders:       len(cd.Reminders),
+		PendingMessages: len(cd.PendingMessages),
+	}
+	for _, day := range cd.Schedule {
+		cd.Stats.Classes += len(day)
+	}
+	return cd, nil
+}
+
+// sendChatData sends all data of chat as json file to another chat, or to the same one. File goes through Output like other messages, so it is sent within rate limits and retried.
+func (b *Bot) sendChatData(to chatid, chatID chatid, caption string) {
+	cd, err := b.ChatData(chatID)
+	if err != nil {
+		generateDialogLogger(chatID).Error("Could not load chat data")
+		b.Output <- Msg{chatID: to, text: b.T(to, "error.tryLater")}
+		return
+	}
+
+	data, err := json.MarshalIndent(cd, "", "  ")
+	if err != nil {
+		generateDialogLogger(chatID).Error("Could not encode chat data")
+		b.Output <- Msg{chatID: to, text: b.T(to, "error.tryLater")}
+		return
+	}
+
+	fileName := "chat-" + strconv.FormatInt(int64(chatID), 10) + ".json"
+	b.Output <- Msg{chatID: to, text: caption, document: &document{fileName: fileName, data: data}}
+}
+
+// ExportData sends to chat everything bot stores for it as json file.
+func (b *Bot) ExportData(chatID chatid) {
+	b.sendChatData(chatID, chatID, b.T(chatID, "data.exportCaption"))
+}
+
+// DeleteData starts dialog to confirm that all data of chat should be deleted. Then it is deleted from store and its backups. Reminders which are waiting are not sent, because they are not in store anymore.
+func (b *Bot) DeleteData(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	_, err := b.RunDialog(ctx, chatID, []dialogStep{
+		confirmStep(lang, lang.T("data.askConfirm"), lang.T("data.notDeleted")),
+	})
+	if err != nil {
+		return
+	}
+
+	if err := b.Store.DeleteChat(chatID); err != nil {
+		generateDialogLogger(chatID).Error("Could not delete chat data")
+		b.Output <- Msg{chatID: chatID, text: lang.T("data.deleteFailed")}
+		return
+	}
+
+	generateDialogLogger(chatID).Info("Deleted chat data")
+	// language was deleted too, so answer is in language which user chose
+	b.Output <- Msg{chatID: chatID, text: lang.T("data.deleted")}
+}
@@ -2,20 +2,18 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"io/ioutil"
-	"text/template"
+	"html/template"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 const remindersFileName = "reminders.json"
-const dateLayout = "02-01-06 15:04"
 const remindersTemplate = `
-Aktualne przypomnienia:
-{{ range . }}{{ .Title }} - {{ .Date.Format "02-01-06 15:04" }}
+<b>{{ t "reminder.current" }}</b>
+{{ range . }}{{ .Title }} - <code>{{ date .Date }}</code>
 {{ end }}`
 
 // Reminder stores info about reminders date and name.
@@ -26,25 +24,19 @@ type Reminder struct {
 
 type remindersData map[chatid][]Reminder
 
-// writeReminders rewrites reminders in .json file. If file doesn't exists it will create a new one.
-func writeReminders(rd remindersData, ioLogger *log.Entry) error {
-	rdJSON, err := json.Marshal(rd)
-	if err != nil {
-		ioLogger.Error("Could not encode reminders")
-		return err
-	}
-
-	err = ioutil.WriteFile(remindersFileName, rdJSON, 0644)
-	if err != nil {
-		ioLogger.Error("Could not write file")
-		return err
-	}
-	return err
+// reminderKey identifies reminder of chat.
+type reminderKey struct {
+	chatID chatid
+	date   int64
+	title  string
 }
 
-// createFromTemplate creates string with good looking format with info about given reminders.
-func createRemindersFromTemplate(rmndrs []Reminder) (string, error) {
-	tmpl, err := template.New("remindersTemplate").Parse(remindersTemplate)
+// createFromTemplate creates HTML message with good looking format with info about given reminders in given language. Titles are escaped by template.
+func createRemindersFromTemplate(rmndrs []Reminder, lang language) (string, error) {
+	tmpl, err := template.New("remindersTemplate").Funcs(template.FuncMap{
+		"t":    func(key string) string { return lang.T(key) },
+		"date": func(t time.Time) string { return t.Format(lang.DateLayout()) },
+	}).Parse(remindersTemplate)
 	if err != nil {
 		return "", errors.New("template parse error")
 	}
@@ -60,101 +52,147 @@ func createRemindersFromTemplate(rmndrs []Reminder) (string, error) {
 // ShowReminders sends to user all his reminders.
 func (b *Bot) ShowReminders(chatID chatid) {
 	chatLogger := generateDialogLogger(chatID)
-	tmpl, err := createRemindersFromTemplate(b.RemindersData[chatID])
+	rmndrs, err := b.Store.Reminders(chatID)
+	if err != nil {
+		chatLogger.Error("Could not load reminders")
+		return
+	}
+	tmpl, err := createRemindersFromTemplate(rmndrs, b.Language(chatID))
 	if err != nil {
 		chatLogger.Error("Could not parse reminders")
 		return
 	}
-	b.Output <- Msg{chatID, tmpl}
+	b.Output <- Msg{chatID: chatID, text: tmpl, format: formatHTML}
 }
 
-//Remind sends two messages to user with Reminder. One 24 hours(UTC+2, thats why there is 26) before given date, and second one on given Date. After that it will delete reminder from RemindersData.
-func (b *Bot) Remind(reminder Reminder, chatID chatid, index int) {
-	select {
-	case <-time.After(reminder.Date.Sub(time.Now()) - 26*time.Hour):
-		b.Output <- Msg{chatID, "Przypominam: " + reminder.Title + " " + reminder.Date.Format(dateLayout)}
-	}
-	select {
-	case <-time.After(reminder.Date.Sub(time.Now()) - 2*time.Hour):
-		b.Output <- Msg{chatID, "Przypominam: " + reminder.Title + " " + reminder.Date.Format(dateLayout)}
-	}
+// scheduleReminder starts Remind for reminder, unless it is already waiting, e.g. when reminders are set again after reload.
+func (b *Bot) scheduleReminder(chatID chatid, reminder Reminder) {
+	key := reminderKey{chatID, reminder.Date.UnixNano(), reminder.Title}
 
-	rd := b.RemindersData[chatID]
-	if index < len(rd)-1 {
-		copy(rd[index:], rd[index+1:])
+	b.remindersMu.Lock()
+	defer b.remindersMu.Unlock()
+	if b.scheduled[key] {
+		return
 	}
-	rd[len(rd)-1] = Reminder{}
-	rd = rd[:len(rd)-1]
+	b.scheduled[key] = true
+
+	go func() {
+		b.Remind(reminder, chatID)
 
-	b.RemindersData[chatID] = rd
+		b.remindersMu.Lock()
+		delete(b.scheduled, key)
+		b.remindersMu.Unlock()
+	}()
 }
 
-// SetReminders is a starter function for setting all reminders after bot startup. It will delete all old reminders.
-func (b *Bot) SetReminders() {
-	reminders := b.RemindersData
-	for chatID, perChatR := range reminders {
-		for index, rmndr := range perChatR {
-			if rmndr.Date.Before(time.Now().Add(2 * time.Hour)) {
-				if index < len(perChatR)-1 {
-					copy(perChatR[index:], perChatR[index+1:])
-				}
-				perChatR[len(perChatR)-1] = Reminder{}
-				perChatR = perChatR[:len(perChatR)-1]
-			} else {
-				go b.Remind(rmndr, chatID, index)
-			}
+// reminderExists checks if reminder is still in store, it could be removed when data was reloaded. If store can't be read, reminder is assumed to exist, so it isn't lost.
+func (b *Bot) reminderExists(chatID chatid, reminder Reminder) bool {
+	rmndrs, err := b.Store.Reminders(chatID)
+	if err != nil {
+		generateDialogLogger(chatID).Error("Could not load reminders")
+		return true
+	}
+	for _, r := range rmndrs {
+		if r.Date.Equal(reminder.Date) && r.Title == reminder.Title {
+			return true
 		}
-		b.RemindersData[chatID] = perChatR
 	}
+	return false
+}
+
+// Remind sends message with Reminder to user at every offset before reminder's date, e.g. 26 and 2 hours before in UTC+2. After that it will delete reminder from store. It stops when bot shuts down, reminder stays in store and is set again after restart. It also stops if reminder was removed from store.
+func (b *Bot) Remind(reminder Reminder, chatID chatid) {
+	pendingReminders.Inc()
+	defer pendingReminders.Dec()
 
-	ioLogger := generateIoLogger(remindersFileName, "setReminders")
+	for _, offset := range b.ReminderOffsets {
+		select {
+		case <-time.After(time.Until(reminder.Date.Add(-offset))):
+		case <-b.quit:
+			return
+		}
+		if !b.reminderExists(chatID, reminder) {
+			return
+		}
+		lang := b.Language(chatID)
+		b.SendPersistent(chatID, lang.T("reminder.remind", reminder.Title, reminder.Date.Format(lang.DateLayout())))
+	}
 
-	writeReminders(reminders, ioLogger)
+	if err := b.Store.DeleteReminder(chatID, reminder); err != nil {
+		generateDialogLogger(chatID).Error("Could not delete reminder")
+	}
 }
 
-// AddReminder starts dialog to create new reminder. Then it will start Remind function and write new reminder to RemindersData.
-func (b *Bot) AddReminder(chatID chatid) {
-	chatLogger := generateDialogLogger(chatID)
-	ioLogger := generateIoLogger(remindersFileName, "addReminder")
-	defer func() { b.InactiveInput <- chatID }()
-	rd := b.RemindersData
+// lastReminderTime returns the latest date for which reminder can still be sent, reminders before it are too late.
+func (b *Bot) lastReminderTime() time.Time {
+	return time.Now().Add(b.ReminderOffsets[len(b.ReminderOffsets)-1])
+}
 
-	d, err := b.Dialog(chatID, "Podaj date w formacie DD-MM-RR HH:MM")
-	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	date, err := time.Parse(dateLayout, d)
+// SetReminders is a starter function for setting all reminders after bot startup or data reload. It will delete all old reminders.
+func (b *Bot) SetReminders() {
+	reminders, err := b.Store.AllReminders()
 	if err != nil {
-		b.Output <- Msg{chatID, "Niepoprawna format daty"}
+		log.Error("Could not load reminders")
 		return
 	}
 
-	if date.Before(time.Now().Add(2 * time.Hour)) {
-		b.Output <- Msg{chatID, "Data jest z przeszłości, spróbuj ponownie"}
-		return
+	for chatID, perChatR := range reminders {
+		for _, rmndr := range perChatR {
+			if rmndr.Date.Before(b.lastReminderTime()) {
+				if err := b.Store.DeleteReminder(chatID, rmndr); err != nil {
+					generateDialogLogger(chatID).Error("Could not delete old reminder")
+				}
+				continue
+			}
+			b.scheduleReminder(chatID, rmndr)
+		}
 	}
+}
 
-	t, err := b.Dialog(chatID, "Podaj tytuł przypomnienia")
+// AddReminder starts dialog to create new reminder. Then it will save new reminder in store and start Remind function. Chat can't have more reminders than bot's limit.
+func (b *Bot) AddReminder(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	if b.Limits.MaxReminders > 0 {
+		rmndrs, err := b.Store.Reminders(chatID)
+		if err != nil {
+			generateDialogLogger(chatID).Error("Could not load reminders")
+			b.Output <- Msg{chatID: chatID, text: lang.T("error.tryLater")}
+			return
+		}
+		if len(rmndrs) >= b.Limits.MaxReminders {
+			b.Output <- Msg{chatID: chatID, text: lang.T("reminder.limit", b.Limits.MaxReminders)}
+			return
+		}
+	}
+	answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+		{
+			Name:   "date",
+			Prompt: prompt(lang.T("reminder.askDate")),
+			Parse: func(answer string, _ dialogAnswers) (interface{}, error) {
+				date, err := time.Parse(lang.DateLayout(), answer)
+				if err != nil {
+					return nil, invalidAnswer(lang.T("reminder.badDate"))
+				}
+				if date.Before(b.lastReminderTime()) {
+					return nil, invalidAnswer(lang.T("reminder.pastDate"))
+				}
+				return date, nil
+			},
+		},
+		{Name: "title", Prompt: prompt(lang.T("reminder.askTitle"))},
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
 		return
 	}
 
-	rmndr := Reminder{date, t}
-
-	if rd[chatID] == nil {
-		rd[chatID] = []Reminder{}
-	}
+	rmndr := Reminder{answers.Time("date"), answers.String("title")}
 
-	rd[chatID] = append(rd[chatID], rmndr)
-	go b.Remind(rmndr, chatID, len(rd[chatID])-1)
-
-	err = writeReminders(rd, ioLogger)
+	err = b.Store.AddReminder(chatID, rmndr)
 	if err != nil {
-		b.Output <- Msg{chatID, "Wystapil problem, moga wystapic problemy z tym przypomnieniem w przyszlosci, skontaktuj sie z administratorem"}
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.saveReminder")}
+		return
 	}
+	b.scheduleReminder(chatID, rmndr)
 
-	b.RemindersData[chatID] = rd[chatID]
-	b.Output <- Msg{chatID, "Dodano przypomnienie"}
+	b.Output <- Msg{chatID: chatID, text: lang.T("reminder.added")}
 }
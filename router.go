@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"math"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// commandHandler handles command sent in update. Dialog commands get context of their session, other commands get background context.
+type commandHandler func(ctx context.Context, u Update)
+
+// middleware wraps command handler, e.g. to log it or to decide if it can be run.
+type middleware func(c *command, next commandHandler) commandHandler
+
+// command describes a single bot command.
+// Name is command with slash, e.g. "/dodajfiszke".
+// Args is catalog key of arguments description shown in help, e.g. "{nazwa}".
+// Description is catalog key of text shown in help and in telegram's command list.
+// Aliases are other names of the same command, they are not shown in help.
+// Dialog means command opens a dialog, so it runs in a new session which cancels dialog opened before.
+// Inline optionally reports that dialog command got everything it needs in update, e.g. in payload, so it runs without session and doesn't cancel opened dialog.
+// Admin means only admins can run command, it is not shown in help.
+// Destructive means command deletes data, in groups only group admins can run it.
+type command struct {
+	Name        string
+	Args        string
+	Description string
+	Aliases     []string
+	Dialog      bool
+	Inline      func(u Update) bool
+	Admin       bool
+	Destructive bool
+	Handler     commandHandler
+}
+
+// opensDialog reports if command run by update needs its own session.
+func (c *command) opensDialog(u Update) bool {
+	return c.Dialog && (c.Inline == nil || !c.Inline(u))
+}
+
+// commandInfo is command name and description passed to messenger, so it can show users list of commands.
+type commandInfo struct {
+	Name        string
+	Description string
+}
+
+// commandRouter keeps registered commands and middleware applied to all of them.
+type commandRouter struct {
+	commands    []*command
+	byName      map[string]*command
+	middlewares []middleware
+}
+
+// newCommandRouter creates router without any commands.
+func newCommandRouter() *commandRouter {
+	return &commandRouter{byName: make(map[string]*command)}
+}
+
+// Register adds command under its name and aliases.
+func (r *commandRouter) Register(c *command) {
+	r.commands = append(r.commands, c)
+	r.byName[c.Name] = c
+	for _, alias := range c.Aliases {
+		r.byName[alias] = c
+	}
+}
+
+// Use adds middleware. Middleware added first is the outermost one.
+func (r *commandRouter) Use(m middleware) {
+	r.middlewares = append(r.middlewares, m)
+}
+
+// Find returns command registered under given name or alias.
+func (r *commandRouter) Find(name string) (*command, bool) {
+	c, ok := r.byName[strings.ToLower(name)]
+	return c, ok
+}
+
+// Wrap returns handler of given command wrapped in all middleware.
+func (r *commandRouter) Wrap(c *command, h commandHandler) commandHandler {
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		h = r.middlewares[i](c, h)
+	}
+	return h
+}
+
+// Help returns list of commands with their arguments and descriptions in given language.
+func (r *commandRouter) Help(lang language) string {
+	var sb strings.Builder
+	sb.WriteString("\n")
+	for _, c := range r.commands {
+		if c.Admin {
+			continue
+		}
+		sb.WriteString(c.Name)
+		if c.Args != "" {
+			sb.WriteString(" " + lang.T(c.Args))
+		}
+		sb.WriteString(" - " + lang.T(c.Description) + "\n")
+	}
+	return sb.String()
+}
+
+// Infos returns names without slash and descriptions of all commands in given language.
+func (r *commandRouter) Infos(lang language) []commandInfo {
+	infos := make([]commandInfo, 0, len(r.commands))
+	for _, c := range r.commands {
+		if c.Admin {
+			continue
+		}
+		infos = append(infos, commandInfo{strings.TrimPrefix(c.Name, "/"), lang.T(c.Description)})
+	}
+	return infos
+}
+
+// recoveryMiddleware stops panic in command handler from crashing the bot and tells user that something went wrong.
+func (b *Bot) recoveryMiddleware(c *command, next commandHandler) commandHandler {
+	return func(ctx context.Context, u Update) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.WithFields(log.Fields{
+					"chat":    u.ChatID,
+					"command": c.Name,
+					"panic":   r,
+					"stack":   string(debug.Stack()),
+				}).Error("Command handler panicked")
+				b.Output <- Msg{chatID: u.ChatID, text: b.T(u.ChatID, "error.tryLater")}
+			}
+		}()
+		next(ctx, u)
+	}
+}
+
+// loggingMiddleware logs every handled command and how long it took.
+func loggingMiddleware(c *command, next commandHandler) commandHandler {
+	return func(ctx context.Context, u Update) {
+		start := time.Now()
+		next(ctx, u)
+		log.WithFields(log.Fields{
+			"chat":     u.ChatID,
+			"user":     u.UserID,
+			"command":  c.Name,
+			"duration": time.Since(start).String(),
+		}).Debug("Command handled")
+	}
+}
+
+// authorizer decides if user can run command. Authorize returns error if user is not allowed to run it.
+type authorizer interface {
+	Authorize(u Update, c *command) error
+}
+
+// allowAll is authorizer which lets everyone run every command.
+type allowAll struct{}
+
+func (allowAll) Authorize(Update, *command) error {
+	return nil
+}
+
+// authMiddleware runs command only if bot's authorizer allows it.
+func (b *Bot) authMiddleware(c *command, next commandHandler) commandHandler {
+	return func(ctx context.Context, u Update) {
+		if err := b.Authorizer.Authorize(u, c); err != nil {
+			log.WithFields(log.Fields{
+				"chat":    u.ChatID,
+				"user":    u.UserID,
+				"command": c.Name,
+			}).Info("Command not authorized: " + err.Error())
+			key := "error.notAuthorized"
+			if err == errNotGroupAdmin {
+				key = "error.notGroupAdmin"
+			}
+			b.Output <- Msg{chatID: u.ChatID, text: b.T(u.ChatID, key)}
+			return
+		}
+		next(ctx, u)
+	}
+}
+
+// rateLimiter decides if update can be handled now. If it can't, throttle tells how long to wait.
+type rateLimiter interface {
+	Allow(u Update, c *command) (throttle, bool)
+}
+
+// rateLimitMiddleware drops commands which bot's rate limiter doesn't allow. User is told how long to wait only once, further commands are dropped silently until he can run them again.
+func (b *Bot) rateLimitMiddleware(c *command, next commandHandler) commandHandler {
+	return func(ctx context.Context, u Update) {
+		t, ok := b.Limiter.Allow(u, c)
+		if !ok {
+			log.WithFields(log.Fields{
+				"chat":    u.ChatID,
+				"user":    u.UserID,
+				"command": c.Name,
+				"wait":    t.wait.String(),
+			}).Info("Command rate limited")
+			commandsThrottled.Inc()
+			if !t.repeated {
+				seconds := int(math.Ceil(t.wait.Seconds()))
+				b.Output <- Msg{chatID: u.ChatID, text: b.T(u.ChatID, "error.rateLimited", seconds)}
+			}
+			return
+		}
+		next(ctx, u)
+	}
+}
@@ -2,14 +2,12 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"io/ioutil"
+	"html/template"
+	"strconv"
 	"strings"
-	"text/template"
 	"time"
-
-	log "github.com/sirupsen/logrus"
 )
 
 const schedulesFileName = "schedules.json"
@@ -28,7 +26,7 @@ const (
 	sunday    Weekday = 7
 )
 const dayTemplate = `
-{{ range . }}{{ .Starts.Format "15:04" }} - {{ .Ends.Format "15:04" }} - {{ .Name }}
+{{ range . }}<code>{{ .Starts.Format "15:04" }} - {{ .Ends.Format "15:04" }}</code> - {{ .Name }}
 {{ end }}`
 
 // Class keeps info about time and name of class.
@@ -41,58 +39,34 @@ type Class struct {
 	Name   string
 }
 
-func weekdaysPL() map[string]Weekday {
-	return map[string]Weekday{
-		"poniedziałek": monday,
-		"wtorek":       tuesday,
-		"środa":        wednesday,
-		"czwartek":     thursday,
-		"piątek":       friday,
-		"sobota":       saturday,
-		"niedziela":    sunday,
+// parseWeekday returns weekday with given name in any supported language, so user can write it in the language he knows best.
+func parseWeekday(name string) (Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, lang := range languages() {
+		for wd := monday; wd <= sunday; wd++ {
+			if name == wd.Name(lang) {
+				return wd, true
+			}
+		}
 	}
+	return 0, false
 }
 
 type schoolDay []Class
 type schedule map[Weekday]schoolDay
 type schedulesData map[chatid]schedule
 
-// GetString returns polish name of weekday
-func (day Weekday) GetString() string {
-	names := [...]string{
-		"poniedziałek",
-		"wtorek",
-		"środa",
-		"czwartek",
-		"piątek",
-		"sobota",
-		"niedziela",
-	}
-
+// Name returns name of weekday in given language
+func (day Weekday) Name(lang language) string {
 	if day > sunday || day < monday {
 		return "Unknown"
 	}
 
-	return names[day-1]
-}
-
-func writeSchedule(sd schedulesData, ioLogger *log.Entry) error {
-	sdJSON, err := json.Marshal(sd)
-	if err != nil {
-		ioLogger.Error("Could not encode schedules")
-		return err
-	}
-
-	err = ioutil.WriteFile(schedulesFileName, sdJSON, 0644)
-	if err != nil {
-		ioLogger.Error("Could not write file")
-		return err
-	}
-	return err
+	return lang.T("weekday." + strconv.Itoa(int(day)))
 }
 
-// createDayFromTemplate creates string with good looking format with info about given schedule.
-func createDayFromTemplate(day schoolDay, wd Weekday) (string, error) {
+// createDayFromTemplate creates HTML message with good looking format with info about given schedule. Weekday is bold and times are monospace, names of classes are escaped by template.
+func createDayFromTemplate(day schoolDay, wd Weekday, lang language) (string, error) {
 	tmpl, err := template.New("dayTemplate").Parse(dayTemplate)
 	if err != nil {
 		return "", errors.New("template parse error")
@@ -104,17 +78,17 @@ func createDayFromTemplate(day schoolDay, wd Weekday) (string, error) {
 		return "", errors.New("template execute error")
 	}
 
-	msg := wd.GetString() + answerBuff.String()
+	msg := bold(wd.Name(lang)) + answerBuff.String()
 	return msg, nil
 }
 
-func createScheduleFromTemplate(sd schedule) (string, error) {
+func createScheduleFromTemplate(sd schedule, lang language) (string, error) {
 	var msg string
 	for d := 0; d < 10; d++ {
 		wd := Weekday(d)
 		day := sd[wd]
 		if len(day) > 0 {
-			tmplt, err := createDayFromTemplate(day, wd)
+			tmplt, err := createDayFromTemplate(day, wd, lang)
 			if err != nil {
 				return "", err
 			}
@@ -143,16 +117,18 @@ func findClassByName(sd schoolDay, n string) (Class, error) {
 	return c, errors.New("did not found")
 }
 
+// insertClassCorrectly returns new school day with class inserted before first class which starts later. It doesn't modify given day, because appending to its beginning could overwrite classes after it.
 func insertClassCorrectly(sd schoolDay, c Class) schoolDay {
+	day := make(schoolDay, 0, len(sd)+1)
 	for index, cl := range sd {
 		if c.Starts.Before(cl.Starts) {
-			begin := sd[:index]
-			begin = append(begin, c)
-			end := sd[index:]
-			return append(begin, end...) // hack because mutabiliti sriti
+			day = append(day, sd[:index]...)
+			day = append(day, c)
+			return append(day, sd[index:]...)
 		}
 	}
-	return append(sd, c)
+	day = append(day, sd...)
+	return append(day, c)
 }
 
 func deleteClass(sd schoolDay, n string) schoolDay {
@@ -166,246 +142,183 @@ func deleteClass(sd schoolDay, n string) schoolDay {
 	return sd
 }
 
-// AddClass launch dialog for creating a new class. It checks if class exists and if not it will add class to schedules and save it in a file
-func (b *Bot) AddClass(chatID chatid) {
-	chatLogger := generateDialogLogger(chatID)
-	ioLogger := generateIoLogger(schedulesFileName, "addClass")
-	defer func() { b.InactiveInput <- chatID }()
-
-	sd := b.SchedulesData
-	w, err := b.Dialog(chatID, "Podaj dzień tygodnia")
+// updateSchoolDay loads chat's classes in given weekday, changes them with update and saves them in store.
+func (b *Bot) updateSchoolDay(chatID chatid, wd Weekday, update func(schoolDay) schoolDay) error {
+	sd, err := b.Store.Schedule(chatID)
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	w = strings.ToLower(w)
-	wdpl := weekdaysPL()
-	if wdpl[w] == 0 {
-		b.Output <- Msg{chatID, "Nie znam takiego dnia :("}
-		return
-	}
-	wd := wdpl[w]
-
-	s, err := b.Dialog(chatID, "Podaj godzinę rozpoczęcia w formacie HH:MM")
-	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	start, err := time.Parse(timeLayout, s)
-	if err != nil {
-		b.Output <- Msg{chatID, "Niepoprawny format godziny"}
-		return
-	}
-
-	e, err := b.Dialog(chatID, "Podaj godzinę zakończenia w formacie HH:MM")
-	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	end, err := time.Parse(timeLayout, e)
-	if err != nil {
-		b.Output <- Msg{chatID, "Niepoprawny format godziny"}
-		return
-	}
-
-	if end.Before(start) {
-		b.Output <- Msg{chatID, "Zajęcia nie mogą się kończy przed rozpoczęciem :/"}
-		return
-	}
-
-	n, err := b.Dialog(chatID, "Podaj nazwę")
-	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-
-	if classExists(sd[chatID][wd], n) {
-		b.Output <- Msg{chatID, "Podane zajęcia są juz zapisane"}
-		return
+		return err
 	}
+	return b.Store.SetSchoolDay(chatID, wd, update(sd[wd]))
+}
 
-	c := Class{start, end, n}
-
-	if sd[chatID] == nil {
-		sd[chatID] = schedule{}
+// weekdayStep asks for day of the week.
+func weekdayStep(lang language) dialogStep {
+	return dialogStep{
+		Name:   "weekday",
+		Prompt: prompt(lang.T("schedule.askWeekday")),
+		Parse: func(answer string, _ dialogAnswers) (interface{}, error) {
+			wd, ok := parseWeekday(answer)
+			if !ok {
+				return nil, invalidAnswer(lang.T("schedule.unknownWeekday"))
+			}
+			return wd, nil
+		},
 	}
+}
 
-	if sd[chatID][wd] == nil {
-		sd[chatID][wd] = schoolDay{}
+// hourStep asks for hour in HH:MM format. If after is not empty, hour can't be before hour given in step with that name.
+func hourStep(lang language, name string, question string, after string) dialogStep {
+	return dialogStep{
+		Name:   name,
+		Prompt: prompt(question),
+		Parse: func(answer string, answers dialogAnswers) (interface{}, error) {
+			hour, err := time.Parse(timeLayout, answer)
+			if err != nil {
+				return nil, invalidAnswer(lang.T("schedule.badHour"))
+			}
+			if after != "" && hour.Before(answers.Time(after)) {
+				return nil, invalidAnswer(lang.T("schedule.endBeforeStart"))
+			}
+			return hour, nil
+		},
 	}
+}
 
-	sd[chatID][wd] = insertClassCorrectly(sd[chatID][wd], c)
-
-	err = writeSchedule(sd, ioLogger)
-	if err != nil {
-		b.Output <- Msg{chatID, "Wystapil problem, moga wystapic problemy z tymi zajęciami w przyszlosci, skontaktuj sie z administratorem"}
+// classStep asks for name of class in weekday given in previous step. If mustExist is true, class has to exist, otherwise it can't exist.
+func (b *Bot) classStep(chatID chatid, lang language, mustExist bool) dialogStep {
+	return dialogStep{
+		Name:   "name",
+		Prompt: prompt(lang.T("schedule.askName")),
+		Parse: func(answer string, answers dialogAnswers) (interface{}, error) {
+			sd, err := b.Store.Schedule(chatID)
+			if err != nil {
+				return nil, err
+			}
+			exists := classExists(sd[answers.Weekday("weekday")], answer)
+			if mustExist && !exists {
+				return nil, invalidAnswer(lang.T("schedule.classNotFound"))
+			}
+			if !mustExist && exists {
+				return nil, invalidAnswer(lang.T("schedule.classExists"))
+			}
+			return answer, nil
+		},
 	}
-
-	b.SchedulesData[chatID][wd] = sd[chatID][wd]
-	b.Output <- Msg{chatID, "Dodano zajęcia"}
 }
 
-// EditClass launch dialog for editing a class. It checks if class exists and if then it will edit class and save it in a file
-func (b *Bot) EditClass(chatID chatid) {
-	chatLogger := generateDialogLogger(chatID)
-	ioLogger := generateIoLogger(schedulesFileName, "editClass")
-	defer func() { b.InactiveInput <- chatID }()
-
-	sd := b.SchedulesData
-	w, err := b.Dialog(chatID, "Podaj dzień tygodnia")
+// AddClass launch dialog for creating a new class. It checks if class exists and if not it will add class to chat's schedule in store
+func (b *Bot) AddClass(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+		weekdayStep(lang),
+		hourStep(lang, "start", lang.T("schedule.askStart"), ""),
+		hourStep(lang, "end", lang.T("schedule.askEnd"), "start"),
+		b.classStep(chatID, lang, false),
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	w = strings.ToLower(w)
-	wdpl := weekdaysPL()
-	if wdpl[w] == 0 {
-		b.Output <- Msg{chatID, "Nie znam takiego dnia :("}
 		return
 	}
-	wd := wdpl[w]
+	wd := answers.Weekday("weekday")
 
-	n, err := b.Dialog(chatID, "Podaj nazwę")
-	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
+	c := Class{answers.Time("start"), answers.Time("end"), answers.String("name")}
 
-	_, err = findClassByName(sd[chatID][wd], n)
+	err = b.updateSchoolDay(chatID, wd, func(day schoolDay) schoolDay {
+		return insertClassCorrectly(day, c)
+	})
 	if err != nil {
-		b.Output <- Msg{chatID, "Podane zajęcia nie istnieją"}
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.saveClass")}
 		return
 	}
 
-	s, err := b.Dialog(chatID, "Podaj godzinę rozpoczęcia w formacie HH:MM")
-	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	start, err := time.Parse(timeLayout, s)
-	if err != nil {
-		b.Output <- Msg{chatID, "Niepoprawny format godziny"}
-		return
-	}
+	b.Output <- Msg{chatID: chatID, text: lang.T("schedule.classAdded")}
+}
 
-	e, err := b.Dialog(chatID, "Podaj godzinę zakończenia w formacie HH:MM")
+// EditClass launch dialog for editing a class. It checks if class exists and if then it will edit class and save it in store
+func (b *Bot) EditClass(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+		weekdayStep(lang),
+		b.classStep(chatID, lang, true),
+		hourStep(lang, "start", lang.T("schedule.askStart"), ""),
+		hourStep(lang, "end", lang.T("schedule.askEnd"), "start"),
+		{Name: "newName", Prompt: prompt(lang.T("schedule.askName"))},
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	end, err := time.Parse(timeLayout, e)
-	if err != nil {
-		b.Output <- Msg{chatID, "Niepoprawny format godziny"}
 		return
 	}
+	wd := answers.Weekday("weekday")
 
-	if end.Before(start) {
-		b.Output <- Msg{chatID, "Zajęcia nie mogą się kończy przed rozpoczęciem :/"}
-		return
-	}
+	newC := Class{answers.Time("start"), answers.Time("end"), answers.String("newName")}
 
-	newN, err := b.Dialog(chatID, "Podaj nazwę")
+	err = b.updateSchoolDay(chatID, wd, func(day schoolDay) schoolDay {
+		return insertClassCorrectly(deleteClass(day, answers.String("name")), newC)
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.saveClass")}
 		return
 	}
-	newC := Class{start, end, newN}
-
-	sd[chatID][wd] = deleteClass(sd[chatID][wd], n)
 
-	sd[chatID][wd] = insertClassCorrectly(sd[chatID][wd], newC)
-
-	err = writeSchedule(sd, ioLogger)
-	if err != nil {
-		b.Output <- Msg{chatID, "Wystapil problem, moga wystapic problemy z tymi zajęciami w przyszlosci, skontaktuj sie z administratorem"}
-	}
-
-	b.SchedulesData[chatID][wd] = sd[chatID][wd]
-	b.Output <- Msg{chatID, "Edytowano zajęcia"}
+	b.Output <- Msg{chatID: chatID, text: lang.T("schedule.classEdited")}
 }
 
-// DeleteClass launch dialog for deleting a class. It checks if class exists and if then it will delete class and save it in a file
-func (b *Bot) DeleteClass(chatID chatid) {
-	chatLogger := generateDialogLogger(chatID)
-	ioLogger := generateIoLogger(schedulesFileName, "editClass")
-	defer func() { b.InactiveInput <- chatID }()
-
-	sd := b.SchedulesData
-	w, err := b.Dialog(chatID, "Podaj dzień tygodnia")
+// DeleteClass launch dialog for deleting a class. It checks if class exists and if then it will delete class from store
+func (b *Bot) DeleteClass(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	answers, err := b.RunDialog(ctx, chatID, []dialogStep{
+		weekdayStep(lang),
+		b.classStep(chatID, lang, true),
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
-		return
-	}
-	w = strings.ToLower(w)
-	wdpl := weekdaysPL()
-	if wdpl[w] == 0 {
-		b.Output <- Msg{chatID, "Nie znam takiego dnia :("}
-		return
-	}
-	wd := wdpl[w]
-
-	n, err := b.Dialog(chatID, "Podaj nazwę")
-	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
 		return
 	}
+	wd := answers.Weekday("weekday")
 
-	_, err = findClassByName(sd[chatID][wd], n)
+	err = b.updateSchoolDay(chatID, wd, func(day schoolDay) schoolDay {
+		return deleteClass(day, answers.String("name"))
+	})
 	if err != nil {
-		b.Output <- Msg{chatID, "Podane zajęcia nie istnieją"}
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.saveClass")}
 		return
 	}
 
-	sd[chatID][wd] = deleteClass(sd[chatID][wd], n)
-
-	err = writeSchedule(sd, ioLogger)
-	if err != nil {
-		b.Output <- Msg{chatID, "Wystapil problem, moga wystapic problemy z tymi zajęciami w przyszlosci, skontaktuj sie z administratorem"}
-	}
-
-	b.SchedulesData[chatID][wd] = sd[chatID][wd]
-	b.Output <- Msg{chatID, "Usunięto zajęcia"}
+	b.Output <- Msg{chatID: chatID, text: lang.T("schedule.classDeleted")}
 }
 
 // DeleteSchedule launch dialog for deleting a schedule.
-func (b *Bot) DeleteSchedule(chatID chatid) {
-	chatLogger := generateDialogLogger(chatID)
-	ioLogger := generateIoLogger(schedulesFileName, "editClass")
-	defer func() { b.InactiveInput <- chatID }()
-
-	sd := b.SchedulesData
-	a, err := b.Dialog(chatID, "Napisz 'TAK' zeby usunąć plan")
+func (b *Bot) DeleteSchedule(ctx context.Context, chatID chatid) {
+	lang := b.Language(chatID)
+	_, err := b.RunDialog(ctx, chatID, []dialogStep{
+		confirmStep(lang, lang.T("schedule.askConfirm"), lang.T("schedule.notDeleted")),
+	})
 	if err != nil {
-		chatLogger.Info("Dialog ended unsuccessfully")
 		return
 	}
 
-	if a != "TAK" {
-		b.Output <- Msg{chatID, "Ok, nie usuwamy"}
-		return
-	}
-
-	sd[chatID] = schedule{}
-
-	err = writeSchedule(sd, ioLogger)
+	err = b.Store.DeleteSchedule(chatID)
 	if err != nil {
-		b.Output <- Msg{chatID, "Wystapil problem, moga wystapic problemy z tymi zajęciami w przyszlosci, skontaktuj sie z administratorem"}
+		b.Output <- Msg{chatID: chatID, text: lang.T("error.saveClass")}
+		return
 	}
 
-	b.SchedulesData[chatID] = sd[chatID]
-	b.Output <- Msg{chatID, "Usunięto plan"}
+	b.Output <- Msg{chatID: chatID, text: lang.T("schedule.deleted")}
 }
 
 // ShowSchedule sends to user schedule
 func (b *Bot) ShowSchedule(chatID chatid) {
 	chatLogger := generateDialogLogger(chatID)
-	tmpl, err := createScheduleFromTemplate(b.SchedulesData[chatID])
+	lang := b.Language(chatID)
+	sd, err := b.Store.Schedule(chatID)
+	if err != nil {
+		chatLogger.Error("Could not load schedule")
+		return
+	}
+	tmpl, err := createScheduleFromTemplate(sd, lang)
 	if err != nil {
 		chatLogger.Error("Could not parse schedule")
 		return
 	}
 	if tmpl == "" {
-		tmpl = "Brak zajęć :/"
+		tmpl = lang.T("schedule.empty")
 	}
-	b.Output <- Msg{chatID, tmpl}
+	b.Output <- Msg{chatID: chatID, text: tmpl, format: formatHTML}
 }
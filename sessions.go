@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// session is a dialog opened in a chat.
+// Input receives user's messages, it is never closed, so delivering a message can't panic.
+// Done is closed when dialog goroutine returns.
+type session struct {
+	chatID chatid
+	input  chan string
+	cancel context.CancelFunc
+	ctx    context.Context
+	done   chan struct{}
+}
+
+type sessionKey struct{}
+
+// sessionManager owns dialog state of every chat. Only one dialog can be opened in a chat, starting a new one cancels the previous dialog and waits until it ends.
+// Closed is set by CancelAll, after that no new dialog is started.
+type sessionManager struct {
+	mu       sync.Mutex
+	sessions map[chatid]*session
+	closed   bool
+}
+
+// newSessionManager creates session manager without any opened dialogs.
+func newSessionManager() *sessionManager {
+	return &sessionManager{sessions: make(map[chatid]*session)}
+}
+
+// sessionFromContext returns session under which dialog with given context runs.
+func sessionFromContext(ctx context.Context) *session {
+	s, _ := ctx.Value(sessionKey{}).(*session)
+	return s
+}
+
+// Start cancels dialog opened in chat, waits until it ends and then runs dialog in a new goroutine. Context given to dialog is cancelled when other dialog starts in the same chat.
+func (sm *sessionManager) Start(chatID chatid, dialog func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &session{
+		chatID: chatID,
+		input:  make(chan string),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	s.ctx = context.WithValue(ctx, sessionKey{}, s)
+
+	sm.mu.Lock()
+	if sm.closed {
+		sm.mu.Unlock()
+		cancel()
+		return
+	}
+	previous := sm.sessions[chatID]
+	sm.sessions[chatID] = s
+	sm.mu.Unlock()
+
+	if previous != nil {
+		previous.cancel()
+		<-previous.done
+	}
+
+	go func() {
+		defer sm.finish(s)
+		dialog(s.ctx)
+	}()
+}
+
+// finish cancels session context, so nobody waits for delivering to it anymore, and removes session if it wasn't replaced.
+func (sm *sessionManager) finish(s *session) {
+	s.cancel()
+
+	sm.mu.Lock()
+	if sm.sessions[s.chatID] == s {
+		delete(sm.sessions, s.chatID)
+	}
+	sm.mu.Unlock()
+
+	close(s.done)
+}
+
+// Deliver passes message to dialog opened in chat. It returns false if there is no dialog or dialog ended before reading the message.
+func (sm *sessionManager) Deliver(chatID chatid, text string) bool {
+	sm.mu.Lock()
+	s, ok := sm.sessions[chatID]
+	sm.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	select {
+	case s.input <- text:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// Active returns number of opened dialogs.
+func (sm *sessionManager) Active() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return len(sm.sessions)
+}
+
+// Cancel ends dialog opened in chat and waits until it returns. It returns false if there was no dialog.
+func (sm *sessionManager) Cancel(chatID chatid) bool {
+	sm.mu.Lock()
+	s, ok := sm.sessions[chatID]
+	sm.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	s.cancel()
+	<-s.done
+	return true
+}
+
+// CancelAll ends all opened dialogs, waits until they return and doesn't let new dialogs start. It returns chats which had opened dialog.
+func (sm *sessionManager) CancelAll() []chatid {
+	sm.mu.Lock()
+	sm.closed = true
+	sessions := make([]*session, 0, len(sm.sessions))
+	for _, s := range sm.sessions {
+		sessions = append(sessions, s)
+	}
+	sm.mu.Unlock()
+
+	chats := make([]chatid, 0, len(sessions))
+	for _, s := range sessions {
+		s.cancel()
+		<-s.done
+		chats = append(chats, s.chatID)
+	}
+	return chats
+}
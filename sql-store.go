@@ -0,0 +1,898 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	// pure go sqlite driver, so bot can be built without cgo
+	_ "modernc.org/sqlite"
+)
+
+const defaultSQLitePath = "student-assistant-bot.db"
+
+// sqlTimeLayout is used for all times kept in database, so they can be compared as text.
+const sqlTimeLayout = time.RFC3339Nano
+
+// sqlMigrations are applied in order, version of migration is its index + 1. Never change migration which was released, add a new one instead.
+var sqlMigrations = []string{
+	`CREATE TABLE chats (
+		id INTEGER PRIMARY KEY
+	);
+	CREATE TABLE topics (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		UNIQUE (chat_id, name)
+	);
+	CREATE TABLE flashcards (
+		topic_id INTEGER NOT NULL REFERENCES topics(id) ON DELETE CASCADE,
+		term TEXT NOT NULL,
+		definition TEXT NOT NULL,
+		PRIMARY KEY (topic_id, term)
+	);
+	CREATE TABLE reminders (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+		date TEXT NOT NULL,
+		title TEXT NOT NULL
+	);
+	CREATE INDEX reminders_chat_id ON reminders (chat_id);
+	CREATE TABLE classes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+		weekday INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		starts TEXT NOT NULL,
+		ends TEXT NOT NULL,
+		name TEXT NOT NULL
+	);
+	CREATE INDEX classes_chat_id_weekday ON classes (chat_id, weekday);`,
+	`CREATE TABLE pending_messages (
+		id TEXT PRIMARY KEY,
+		chat_id INTEGER NOT NULL,
+		text TEXT NOT NULL,
+		created TEXT NOT NULL
+	);`,
+	`ALTER TABLE chats ADD COLUMN language TEXT NOT NULL DEFAULT '';`,
+}
+
+// sqlStore is a Store which keeps data in SQLite database. Every change is a single transaction.
+// If keys are configured, texts written by users are encrypted: topic names, terms, definitions, reminder titles, class names and pending messages. Encrypted values differ every time, so topics and terms are found by decrypting all of them in chat or topic.
+type sqlStore struct {
+	db   *sql.DB
+	path string
+	keys *keyring
+}
+
+// generateSQLLogger creates logger for any database related errors.
+func generateSQLLogger(path string, funcname string) *log.Entry {
+	return log.WithFields(log.Fields{
+		"db":   path,
+		"func": funcname,
+	})
+}
+
+// newSQLStore opens database in given file and migrates it to the newest schema. Keys encrypt texts written by users, nil keys mean no encryption.
+func newSQLStore(path string, keys *keyring) (*sqlStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		generateSQLLogger(path, "newSQLStore").Error("Could not open database")
+		return nil, err
+	}
+	// sqlite allows only one writer, with single connection transactions wait for each other instead of failing with busy error
+	db.SetMaxOpenConns(1)
+
+	s := &sqlStore{db: db, path: path, keys: keys}
+	if err = s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// database is created by driver with permissions readable by everyone
+	if err = os.Chmod(path, 0600); err != nil {
+		generateSQLLogger(path, "newSQLStore").Warn("Could not change database permissions")
+	}
+	return s, nil
+}
+
+// foreignKeysConnector opens sqlite connections with foreign keys enabled. Sqlite enables them per connection, so they have to be enabled on every connection which pool opens, otherwise ON DELETE CASCADE doesn't work on it.
+type foreignKeysConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c foreignKeysConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := conn.Prepare("PRAGMA foreign_keys = ON")
+	if err == nil {
+		_, err = stmt.Exec(nil)
+		stmt.Close()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, errors.New("could not enable foreign keys: " + err.Error())
+	}
+	return conn, nil
+}
+
+func (c foreignKeysConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openSQLite opens database with given data source name, every connection has foreign keys enabled.
+func openSQLite(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// database isn't connected yet, it is only used to get driver
+	drv := db.Driver()
+	db.Close()
+	return sql.OpenDB(foreignKeysConnector{driver: drv, dsn: dsn}), nil
+}
+
+// migrate applies all migrations which were not applied yet.
+func (s *sqlStore) migrate() error {
+	sqlLogger := generateSQLLogger(s.path, "migrate")
+
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		sqlLogger.Error("Could not create migrations table")
+		return err
+	}
+
+	var current int
+	err = s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		sqlLogger.Error("Could not read schema version")
+		return err
+	}
+	if current > len(sqlMigrations) {
+		return errors.New("database schema is newer than bot, update the bot")
+	}
+
+	for version := current + 1; version <= len(sqlMigrations); version++ {
+		err = s.inTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqlMigrations[version-1]); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)", version, time.Now().UTC().Format(sqlTimeLayout))
+			return err
+		})
+		if err != nil {
+			sqlLogger.WithField("version", version).Error("Could not apply migration")
+			return err
+		}
+		sqlLogger.WithField("version", version).Info("Applied migration")
+	}
+	return nil
+}
+
+// inTx runs f in transaction. Transaction is commited only if f doesn't return error.
+func (s *sqlStore) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureChat adds chat if it doesn't exist yet.
+func ensureChat(tx *sql.Tx, chatID chatid) error {
+	_, err := tx.Exec("INSERT OR IGNORE INTO chats (id) VALUES (?)", chatID)
+	return err
+}
+
+// sqlQuerier is database or transaction, so lookups can be used in both.
+type sqlQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// findTopic returns ID of chat's topic with given name. Names can be encrypted with random nonce, so they are decrypted and compared here instead of in query.
+func (s *sqlStore) findTopic(q sqlQuerier, chatID chatid, top topic) (int64, bool, error) {
+	rows, err := q.Query("SELECT id, name FROM topics WHERE chat_id = ?", chatID)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return 0, false, err
+		}
+		if name, err = s.keys.openString(name); err != nil {
+			return 0, false, err
+		}
+		if topic(name) == top {
+			return id, true, nil
+		}
+	}
+	return 0, false, rows.Err()
+}
+
+// findFlashcard returns row ID and definition of flashcard with given term in topic. Terms are compared after decryption like topic names.
+func (s *sqlStore) findFlashcard(q sqlQuerier, topicID int64, term string) (int64, string, bool, error) {
+	rows, err := q.Query("SELECT rowid, term, definition FROM flashcards WHERE topic_id = ?", topicID)
+	if err != nil {
+		return 0, "", false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rowid int64
+		var t, definition string
+		if err := rows.Scan(&rowid, &t, &definition); err != nil {
+			return 0, "", false, err
+		}
+		if t, err = s.keys.openString(t); err != nil {
+			return 0, "", false, err
+		}
+		if t == term {
+			return rowid, definition, true, nil
+		}
+	}
+	return 0, "", false, rows.Err()
+}
+
+// Flashcards returns all flashcards of chat grouped by topic.
+func (s *sqlStore) Flashcards(chatID chatid) (map[topic]flashcards, error) {
+	rows, err := s.db.Query(`SELECT t.name, f.term, f.definition FROM topics t
+		JOIN flashcards f ON f.topic_id = t.id
+		WHERE t.chat_id = ?`, chatID)
+	if err != nil {
+		generateSQLLogger(s.path, "flashcards").Error("Could not query flashcards")
+		return nil, err
+	}
+	defer rows.Close()
+
+	topics := make(map[topic]flashcards)
+	for rows.Next() {
+		var name, term, definition string
+		if err := rows.Scan(&name, &term, &definition); err != nil {
+			generateSQLLogger(s.path, "flashcards").Error("Could not read flashcard")
+			return nil, err
+		}
+		if name, err = s.keys.openString(name); err == nil {
+			if term, err = s.keys.openString(term); err == nil {
+				definition, err = s.keys.openString(definition)
+			}
+		}
+		if err != nil {
+			generateSQLLogger(s.path, "flashcards").Error("Could not decrypt flashcard")
+			return nil, err
+		}
+		top := topic(name)
+		if topics[top] == nil {
+			topics[top] = make(flashcards)
+		}
+		topics[top][term] = definition
+	}
+	return topics, rows.Err()
+}
+
+// Flashcard returns definition of term in given topic and reports if it exists.
+func (s *sqlStore) Flashcard(chatID chatid, top topic, term string) (string, bool, error) {
+	topicID, ok, err := s.findTopic(s.db, chatID, top)
+	if err != nil || !ok {
+		if err != nil {
+			generateSQLLogger(s.path, "flashcard").Error("Could not query topic")
+		}
+		return "", false, err
+	}
+	_, definition, ok, err := s.findFlashcard(s.db, topicID, term)
+	if err != nil || !ok {
+		if err != nil {
+			generateSQLLogger(s.path, "flashcard").Error("Could not query flashcard")
+		}
+		return "", false, err
+	}
+	if definition, err = s.keys.openString(definition); err != nil {
+		generateSQLLogger(s.path, "flashcard").Error("Could not decrypt flashcard")
+		return "", false, err
+	}
+	return definition, true, nil
+}
+
+// PutFlashcard adds flashcard or replaces its definition.
+func (s *sqlStore) PutFlashcard(chatID chatid, top topic, term string, definition string) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		return s.putFlashcard(tx, chatID, top, term, definition)
+	})
+	if err != nil {
+		generateSQLLogger(s.path, "putFlashcard").Error("Could not save flashcard")
+	}
+	return err
+}
+
+func (s *sqlStore) putFlashcard(tx *sql.Tx, chatID chatid, top topic, term string, definition string) error {
+	if err := ensureChat(tx, chatID); err != nil {
+		return err
+	}
+	definition, err := s.keys.sealString(definition)
+	if err != nil {
+		return err
+	}
+
+	topicID, ok, err := s.findTopic(tx, chatID, top)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		name, err := s.keys.sealString(string(top))
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("INSERT INTO topics (chat_id, name) VALUES (?, ?)", chatID, name)
+		if err != nil {
+			return err
+		}
+		if topicID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+
+	rowid, _, ok, err := s.findFlashcard(tx, topicID, term)
+	if err != nil {
+		return err
+	}
+	if ok {
+		_, err = tx.Exec("UPDATE flashcards SET definition = ? WHERE rowid = ?", definition, rowid)
+		return err
+	}
+	sealedTerm, err := s.keys.sealString(term)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO flashcards (topic_id, term, definition) VALUES (?, ?, ?)", topicID, sealedTerm, definition)
+	return err
+}
+
+// DeleteFlashcard deletes flashcard and its topic if it was the last one.
+func (s *sqlStore) DeleteFlashcard(chatID chatid, top topic, term string) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		topicID, ok, err := s.findTopic(tx, chatID, top)
+		if err != nil || !ok {
+			return err
+		}
+		rowid, _, ok, err := s.findFlashcard(tx, topicID, term)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if _, err := tx.Exec("DELETE FROM flashcards WHERE rowid = ?", rowid); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`DELETE FROM topics
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM flashcards WHERE topic_id = topics.id)`, topicID)
+		return err
+	})
+	if err != nil {
+		generateSQLLogger(s.path, "deleteFlashcard").Error("Could not delete flashcard")
+	}
+	return err
+}
+
+// scanReminder reads reminder from row with date and title columns.
+func (s *sqlStore) scanReminder(rows *sql.Rows) (Reminder, error) {
+	var date, title string
+	if err := rows.Scan(&date, &title); err != nil {
+		return Reminder{}, err
+	}
+	d, err := time.Parse(sqlTimeLayout, date)
+	if err != nil {
+		return Reminder{}, err
+	}
+	if title, err = s.keys.openString(title); err != nil {
+		return Reminder{}, err
+	}
+	return Reminder{Date: d, Title: title}, nil
+}
+
+// Reminders returns all reminders of chat.
+func (s *sqlStore) Reminders(chatID chatid) ([]Reminder, error) {
+	rows, err := s.db.Query("SELECT date, title FROM reminders WHERE chat_id = ? ORDER BY id", chatID)
+	if err != nil {
+		generateSQLLogger(s.path, "reminders").Error("Could not query reminders")
+		return nil, err
+	}
+	defer rows.Close()
+
+	rmndrs := []Reminder{}
+	for rows.Next() {
+		r, err := s.scanReminder(rows)
+		if err != nil {
+			generateSQLLogger(s.path, "reminders").Error("Could not read reminder")
+			return nil, err
+		}
+		rmndrs = append(rmndrs, r)
+	}
+	return rmndrs, rows.Err()
+}
+
+// AllReminders returns reminders of every chat.
+func (s *sqlStore) AllReminders() (remindersData, error) {
+	chats, err := s.chatIDs("SELECT DISTINCT chat_id FROM reminders")
+	if err != nil {
+		generateSQLLogger(s.path, "allReminders").Error("Could not query chats")
+		return nil, err
+	}
+
+	rd := make(remindersData, len(chats))
+	for _, chatID := range chats {
+		if rd[chatID], err = s.Reminders(chatID); err != nil {
+			return nil, err
+		}
+	}
+	return rd, nil
+}
+
+// chatIDs returns chat IDs selected by given query.
+func (s *sqlStore) chatIDs(query string) ([]chatid, error) {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []chatid{}
+	for rows.Next() {
+		var chatID chatid
+		if err := rows.Scan(&chatID); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chatID)
+	}
+	return chats, rows.Err()
+}
+
+// AddReminder adds reminder to chat.
+func (s *sqlStore) AddReminder(chatID chatid, r Reminder) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		return s.addReminder(tx, chatID, r)
+	})
+	if err != nil {
+		generateSQLLogger(s.path, "addReminder").Error("Could not save reminder")
+	}
+	return err
+}
+
+func (s *sqlStore) addReminder(tx *sql.Tx, chatID chatid, r Reminder) error {
+	if err := ensureChat(tx, chatID); err != nil {
+		return err
+	}
+	title, err := s.keys.sealString(r.Title)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO reminders (chat_id, date, title) VALUES (?, ?, ?)", chatID, r.Date.UTC().Format(sqlTimeLayout), title)
+	return err
+}
+
+// DeleteReminder deletes reminder with the same date and title. Encrypted titles can't be compared in query, so titles of reminders with the same date are compared after decrypting.
+func (s *sqlStore) DeleteReminder(chatID chatid, r Reminder) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, title FROM reminders WHERE chat_id = ? AND date = ? ORDER BY id", chatID, r.Date.UTC().Format(sqlTimeLayout))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			var title string
+			if err := rows.Scan(&id, &title); err != nil {
+				return err
+			}
+			if title, err = s.keys.openString(title); err != nil {
+				return err
+			}
+			if title == r.Title {
+				rows.Close()
+				_, err = tx.Exec("DELETE FROM reminders WHERE id = ?", id)
+				return err
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		generateSQLLogger(s.path, "deleteReminder").Error("Could not delete reminder")
+	}
+	return err
+}
+
+// Schedule returns schedule of chat.
+func (s *sqlStore) Schedule(chatID chatid) (schedule, error) {
+	sqlLogger := generateSQLLogger(s.path, "schedule")
+
+	rows, err := s.db.Query("SELECT weekday, starts, ends, name FROM classes WHERE chat_id = ? ORDER BY weekday, position", chatID)
+	if err != nil {
+		sqlLogger.Error("Could not query classes")
+		return nil, err
+	}
+	defer rows.Close()
+
+	sd := schedule{}
+	for rows.Next() {
+		var wd Weekday
+		var starts, ends, name string
+		if err := rows.Scan(&wd, &starts, &ends, &name); err != nil {
+			sqlLogger.Error("Could not read class")
+			return nil, err
+		}
+		if name, err = s.keys.openString(name); err != nil {
+			sqlLogger.Error("Could not decrypt class")
+			return nil, err
+		}
+		c := Class{Name: name}
+		if c.Starts, err = time.Parse(sqlTimeLayout, starts); err != nil {
+			sqlLogger.Error("Could not parse class start")
+			return nil, err
+		}
+		if c.Ends, err = time.Parse(sqlTimeLayout, ends); err != nil {
+			sqlLogger.Error("Could not parse class end")
+			return nil, err
+		}
+		sd[wd] = append(sd[wd], c)
+	}
+	return sd, rows.Err()
+}
+
+// SetSchoolDay replaces all classes of given weekday.
+func (s *sqlStore) SetSchoolDay(chatID chatid, wd Weekday, day schoolDay) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		return s.setSchoolDay(tx, chatID, wd, day)
+	})
+	if err != nil {
+		generateSQLLogger(s.path, "setSchoolDay").Error("Could not save classes")
+	}
+	return err
+}
+
+func (s *sqlStore) setSchoolDay(tx *sql.Tx, chatID chatid, wd Weekday, day schoolDay) error {
+	if err := ensureChat(tx, chatID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM classes WHERE chat_id = ? AND weekday = ?", chatID, wd); err != nil {
+		return err
+	}
+	for i, c := range day {
+		name, err := s.keys.sealString(c.Name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO classes (chat_id, weekday, position, starts, ends, name) VALUES (?, ?, ?, ?, ?, ?)",
+			chatID, wd, i, c.Starts.Format(sqlTimeLayout), c.Ends.Format(sqlTimeLayout), name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSchedule deletes all classes of chat.
+func (s *sqlStore) DeleteSchedule(chatID chatid) error {
+	_, err := s.db.Exec("DELETE FROM classes WHERE chat_id = ?", chatID)
+	if err != nil {
+		generateSQLLogger(s.path, "deleteSchedule").Error("Could not delete classes")
+	}
+	return err
+}
+
+// Language returns language chosen in chat, empty if chat didn't choose any.
+func (s *sqlStore) Language(chatID chatid) (language, error) {
+	var lang string
+	err := s.db.QueryRow("SELECT language FROM chats WHERE id = ?", chatID).Scan(&lang)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		generateSQLLogger(s.path, "language").Error("Could not query language")
+		return "", err
+	}
+	return language(lang), nil
+}
+
+// SetLanguage saves language chosen in chat.
+func (s *sqlStore) SetLanguage(chatID chatid, lang language) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		return setLanguage(tx, chatID, lang)
+	})
+	if err != nil {
+		generateSQLLogger(s.path, "setLanguage").Error("Could not save language")
+	}
+	return err
+}
+
+// setLanguage saves language of chat in transaction.
+func setLanguage(tx *sql.Tx, chatID chatid, lang language) error {
+	if err := ensureChat(tx, chatID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE chats SET language = ? WHERE id = ?", string(lang), chatID)
+	return err
+}
+
+// PendingMessages returns messages which were not delivered yet, oldest first.
+func (s *sqlStore) PendingMessages() ([]pendingMessage, error) {
+	sqlLogger := generateSQLLogger(s.path, "pendingMessages")
+
+	rows, err := s.db.Query("SELECT id, chat_id, text, created FROM pending_messages ORDER BY created, rowid")
+	if err != nil {
+		sqlLogger.Error("Could not query pending messages")
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []pendingMessage{}
+	for rows.Next() {
+		var m pendingMessage
+		var created string
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.Text, &created); err != nil {
+			sqlLogger.Error("Could not read pending message")
+			return nil, err
+		}
+		if m.Created, err = time.Parse(sqlTimeLayout, created); err != nil {
+			sqlLogger.Error("Could not parse pending message time")
+			return nil, err
+		}
+		if m.Text, err = s.keys.openString(m.Text); err != nil {
+			sqlLogger.Error("Could not decrypt pending message")
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// AddPendingMessage saves message which has to be delivered even if bot restarts.
+func (s *sqlStore) AddPendingMessage(m pendingMessage) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		return s.addPendingMessage(tx, m)
+	})
+	if err != nil {
+		generateSQLLogger(s.path, "addPendingMessage").Error("Could not save pending message")
+	}
+	return err
+}
+
+func (s *sqlStore) addPendingMessage(tx *sql.Tx, m pendingMessage) error {
+	text, err := s.keys.sealString(m.Text)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO pending_messages (id, chat_id, text, created) VALUES (?, ?, ?, ?)",
+		m.ID, m.ChatID, text, m.Created.UTC().Format(sqlTimeLayout))
+	return err
+}
+
+// DeletePendingMessage deletes delivered message.
+func (s *sqlStore) DeletePendingMessage(id string) error {
+	_, err := s.db.Exec("DELETE FROM pending_messages WHERE id = ?", id)
+	if err != nil {
+		generateSQLLogger(s.path, "deletePendingMessage").Error("Could not delete pending message")
+	}
+	return err
+}
+
+// DeleteChat deletes chat with all its data and pending messages. Deleted rows can stay in free pages of database file, so it is vacuumed afterwards.
+func (s *sqlStore) DeleteChat(chatID chatid) error {
+	sqlLogger := generateSQLLogger(s.path, "deleteChat")
+
+	err := s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM pending_messages WHERE chat_id = ?", chatID); err != nil {
+			return err
+		}
+		// topics, flashcards, reminders and classes are deleted by cascade
+		_, err := tx.Exec("DELETE FROM chats WHERE id = ?", chatID)
+		return err
+	})
+	if err != nil {
+		sqlLogger.Error("Could not delete chat")
+		return err
+	}
+
+	if _, err = s.db.Exec("VACUUM"); err != nil {
+		sqlLogger.Error("Could not vacuum database")
+	}
+	return err
+}
+
+// encryptedColumns are columns with texts written by users, which are encrypted if keys are configured.
+var encryptedColumns = []struct {
+	table  string
+	column string
+}{
+	{"topics", "name"},
+	{"flashcards", "term"},
+	{"flashcards", "definition"},
+	{"reminders", "title"},
+	{"classes", "name"},
+	{"pending_messages", "text"},
+}
+
+// Recrypt decrypts all encrypted texts and writes them again encrypted with primary key, or as plaintext if keys only decrypt. Afterwards database is vacuumed, so old values don't stay in free pages.
+func (s *sqlStore) Recrypt() error {
+	sqlLogger := generateSQLLogger(s.path, "recrypt")
+
+	err := s.inTx(func(tx *sql.Tx) error {
+		for _, c := range encryptedColumns {
+			if err := s.recryptColumn(tx, c.table, c.column); err != nil {
+				return errors.New(c.table + "." + c.column + ": " + err.Error())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		sqlLogger.Error("Could not recrypt data: " + err.Error())
+		return err
+	}
+
+	if _, err = s.db.Exec("VACUUM"); err != nil {
+		sqlLogger.Error("Could not vacuum database")
+	}
+	return err
+}
+
+// recryptColumn writes again every value of column with current keys.
+func (s *sqlStore) recryptColumn(tx *sql.Tx, table string, column string) error {
+	rows, err := tx.Query("SELECT rowid, " + column + " FROM " + table)
+	if err != nil {
+		return err
+	}
+	type value struct {
+		rowid int64
+		text  string
+	}
+	values := []value{}
+	for rows.Next() {
+		var v value
+		if err := rows.Scan(&v.rowid, &v.text); err != nil {
+			rows.Close()
+			return err
+		}
+		values = append(values, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		text, err := s.keys.openString(v.text)
+		if err != nil {
+			return err
+		}
+		if text, err = s.keys.sealString(text); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE "+table+" SET "+column+" = ? WHERE rowid = ?", text, v.rowid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Chats returns IDs of all chats which have any data, sorted.
+func (s *sqlStore) Chats() ([]chatid, error) {
+	chats, err := s.chatIDs("SELECT id FROM chats ORDER BY id")
+	if err != nil {
+		generateSQLLogger(s.path, "chats").Error("Could not query chats")
+	}
+	return chats, err
+}
+
+// Stats counts data of all chats.
+func (s *sqlStore) Stats() (storeStats, error) {
+	var st storeStats
+	err := s.db.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM chats),
+		(SELECT COUNT(*) FROM topics),
+		(SELECT COUNT(*) FROM flashcards),
+		(SELECT COUNT(*) FROM reminders),
+		(SELECT COUNT(*) FROM classes),
+		(SELECT COUNT(*) FROM pending_messages)`).Scan(&st.Chats, &st.Topics, &st.Flashcards, &st.Reminders, &st.Classes, &st.PendingMessages)
+	if err != nil {
+		generateSQLLogger(s.path, "stats").Error("Could not count data")
+	}
+	return st, err
+}
+
+// Reload does nothing, database is always read directly, so it is never out of date.
+func (s *sqlStore) Reload() error {
+	return nil
+}
+
+// Ping checks if database can be queried.
+func (s *sqlStore) Ping() error {
+	_, err := s.db.Exec("SELECT 1")
+	return err
+}
+
+// Close closes database.
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// ImportJSON copies all data from json store into empty database in one transaction. It refuses to import into database which already has any chats, so import can't be run twice by mistake.
+func (s *sqlStore) ImportJSON(js *jsonStore) error {
+	sqlLogger := generateSQLLogger(s.path, "importJSON")
+
+	var chats int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM chats").Scan(&chats); err != nil {
+		sqlLogger.Error("Could not count chats")
+		return err
+	}
+	if chats > 0 {
+		return errors.New("database is not empty, import has to be done into a new database")
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	err := s.inTx(func(tx *sql.Tx) error {
+		for chatID, topics := range js.flashcards {
+			for top, fc := range topics {
+				for term, definition := range fc {
+					if err := s.putFlashcard(tx, chatID, top, term, definition); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		for chatID, rmndrs := range js.reminders {
+			for _, r := range rmndrs {
+				if err := s.addReminder(tx, chatID, r); err != nil {
+					return err
+				}
+			}
+		}
+		for chatID, sd := range js.schedules {
+			for wd, day := range sd {
+				if err := s.setSchoolDay(tx, chatID, wd, day); err != nil {
+					return err
+				}
+			}
+		}
+		for chatID, lang := range js.languages {
+			if err := setLanguage(tx, chatID, lang); err != nil {
+				return err
+			}
+		}
+		for _, m := range js.outbox {
+			if err := s.addPendingMessage(tx, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		sqlLogger.Error("Could not import data")
+		return err
+	}
+
+	sqlLogger.WithFields(log.Fields{
+		"flashcardChats":  len(js.flashcards),
+		"reminderChats":   len(js.reminders),
+		"scheduleChats":   len(js.schedules),
+		"languageChats":   len(js.languages),
+		"pendingMessages": len(js.outbox),
+	}).Info("Imported json data")
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import "time"
+
+// pendingMessage is a message, e.g. reminder, which is kept in store until it is delivered, so it is sent after restart if bot stopped before.
+type pendingMessage struct {
+	ID      string
+	ChatID  chatid
+	Text    string
+	Created time.Time
+}
+
+// storeStats counts data kept in store.
+type storeStats struct {
+	Chats           int
+	Topics          int
+	Flashcards      int
+	Reminders       int
+	Classes         int
+	PendingMessages int
+}
+
+// Store keeps all bot data by chat ID. Implementations have to be safe for concurrent use and return copies, so callers can modify returned values.
+type Store interface {
+	// Flashcards returns all flashcards of chat grouped by topic.
+	Flashcards(chatID chatid) (map[topic]flashcards, error)
+	// Flashcard returns definition of term in given topic and reports if it exists.
+	Flashcard(chatID chatid, top topic, term string) (string, bool, error)
+	// PutFlashcard adds flashcard or replaces its definition.
+	PutFlashcard(chatID chatid, top topic, term string, definition string) error
+	// DeleteFlashcard deletes flashcard and its topic if it was the last one.
+	DeleteFlashcard(chatID chatid, top topic, term string) error
+
+	// Reminders returns all reminders of chat.
+	Reminders(chatID chatid) ([]Reminder, error)
+	// AllReminders returns reminders of every chat.
+	AllReminders() (remindersData, error)
+	// AddReminder adds reminder to chat.
+	AddReminder(chatID chatid, r Reminder) error
+	// DeleteReminder deletes reminder with the same date and title.
+	DeleteReminder(chatID chatid, r Reminder) error
+
+	// Schedule returns schedule of chat.
+	Schedule(chatID chatid) (schedule, error)
+	// SetSchoolDay replaces all classes of given weekday.
+	SetSchoolDay(chatID chatid, wd Weekday, day schoolDay) error
+	// DeleteSchedule deletes all classes of chat.
+	DeleteSchedule(chatID chatid) error
+
+	// Language returns language chosen in chat, empty if chat didn't choose any.
+	Language(chatID chatid) (language, error)
+	// SetLanguage saves language chosen in chat.
+	SetLanguage(chatID chatid, lang language) error
+
+	// PendingMessages returns messages which were not delivered yet, oldest first.
+	PendingMessages() ([]pendingMessage, error)
+	// AddPendingMessage saves message which has to be delivered even if bot restarts.
+	AddPendingMessage(m pendingMessage) error
+	// DeletePendingMessage deletes delivered message.
+	DeletePendingMessage(id string) error
+
+	// DeleteChat deletes all data of chat, including its copies in backups, so it can't be restored.
+	DeleteChat(chatID chatid) error
+
+	// Chats returns IDs of all chats which have any data, sorted.
+	Chats() ([]chatid, error)
+	// Stats counts data of all chats.
+	Stats() (storeStats, error)
+	// Reload reads data again from its source, so changes made outside of bot are visible.
+	Reload() error
+
+	// Ping reports error if store can't save changes.
+	Ping() error
+	// Close releases resources used by store.
+	Close() error
+}
+
+// copyTopics returns deep copy of chat's flashcards.
+func copyTopics(topics map[topic]flashcards) map[topic]flashcards {
+	c := make(map[topic]flashcards, len(topics))
+	for top, fc := range topics {
+		c[top] = make(flashcards, len(fc))
+		for term, definition := range fc {
+			c[top][term] = definition
+		}
+	}
+	return c
+}
+
+// copySchedule returns deep copy of chat's schedule.
+func copySchedule(sd schedule) schedule {
+	c := make(schedule, len(sd))
+	for wd, day := range sd {
+		c[wd] = append(schoolDay{}, day...)
+	}
+	return c
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tba "gopkg.in/tucnak/telebot.v2" //telegram bot api
+)
+
+// pollErrorWindow is how long after failed request for updates poller is reported as unhealthy. Long polling request lasts 10s, so without new error in this time requests succeed again.
+const pollErrorWindow = 30 * time.Second
+
+// telegramMessenger is a Messenger that talks with users through telegram bot api.
+// Running is set while bot receives updates, lastPollError is the last error reported by telebot with its time.
+type telegramMessenger struct {
+	api    *tba.Bot
+	poller tba.Poller
+
+	mu              sync.Mutex
+	running         bool
+	lastPollError   error
+	lastPollErrorAt time.Time
+}
+
+// newTelegramMessenger creates telegram messenger under given telegram api token. Poller decides if updates are received by long polling or webhook.
+func newTelegramMessenger(token string, poller tba.Poller) (*telegramMessenger, error) {
+	t := &telegramMessenger{poller: poller}
+	tb, err := tba.NewBot(tba.Settings{
+		Token:    token,
+		Poller:   poller,
+		Reporter: t.reportError,
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.api = tb
+
+	// telegram doesn't allow long polling while webhook is set, so webhook left from previous run has to be removed
+	if _, ok := poller.(*tba.LongPoller); ok {
+		if err := tb.RemoveWebhook(); err != nil {
+			log.Error("Could not remove webhook: " + err.Error())
+		}
+	}
+
+	return t, nil
+}
+
+// reportError is called by telebot when getting updates fails or handler panics.
+func (t *telegramMessenger) reportError(err error) {
+	log.Warn("Telegram error: " + err.Error())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastPollError = err
+	t.lastPollErrorAt = time.Now()
+}
+
+// Health reports error if bot doesn't receive updates: it is not started, getting updates failed recently or webhook server doesn't work.
+func (t *telegramMessenger) Health() error {
+	t.mu.Lock()
+	running, lastErr, lastErrAt := t.running, t.lastPollError, t.lastPollErrorAt
+	t.mu.Unlock()
+
+	if !running {
+		return errors.New("not receiving updates")
+	}
+	if hc, ok := t.poller.(interface{ Health() error }); ok {
+		if err := hc.Health(); err != nil {
+			return err
+		}
+	}
+	if lastErr != nil && time.Since(lastErrAt) < pollErrorWindow {
+		return errors.New("getting updates failed: " + lastErr.Error())
+	}
+	return nil
+}
+
+// defaultSendOpt stores default config for sending messages to chat.
+func defaultSendOpt() *tba.SendOptions {
+	return &tba.SendOptions{}
+}
+
+// formatSendOpt returns send options for text in given format.
+func formatSendOpt(format textFormat) *tba.SendOptions {
+	sendOpt := defaultSendOpt()
+	if format == formatHTML {
+		sendOpt.ParseMode = tba.ModeHTML
+	}
+	return sendOpt
+}
+
+// telegramChat wraps chatid to chat object, because it is requirment for tucnak's package.
+func telegramChat(chat chatid) *tba.Chat {
+	return &tba.Chat{ID: int64(chat), Title: "", FirstName: "", LastName: "", Type: "", Username: ""}
+}
+
+// unknownErrorCodeRegexp finds http code in errors which telebot doesn't know, e.g. "telegram unknown: Bad Gateway (502)".
+var unknownErrorCodeRegexp = regexp.MustCompile(`^telegram unknown: .*\((\d+)\)$`)
+
+// classifySendError wraps errors after which sending can be retried in temporaryError: flood wait, server errors and network errors. Other errors, e.g. bot blocked by user, are returned as they are.
+func classifySendError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var flood tba.FloodError
+	if errors.As(err, &flood) {
+		return &temporaryError{err: err, retryAfter: time.Duration(flood.RetryAfter) * time.Second}
+	}
+
+	code := 0
+	var apiErr *tba.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.Code
+	} else if m := unknownErrorCodeRegexp.FindStringSubmatch(err.Error()); m != nil {
+		code, _ = strconv.Atoi(m[1])
+	}
+	if code == http.StatusTooManyRequests || code >= http.StatusInternalServerError {
+		return &temporaryError{err: err}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return &temporaryError{err: err}
+	}
+	return err
+}
+
+// Send sends text message to given chat.
+func (t *telegramMessenger) Send(chat chatid, text string, format textFormat) error {
+	_, err := t.api.Send(telegramChat(chat), text, formatSendOpt(format))
+	return classifySendError(err)
+}
+
+// SendKeyboard sends text message with one time reply keyboard, every option is in a separate row.
+func (t *telegramMessenger) SendKeyboard(chat chatid, text string, format textFormat, options []string) error {
+	keyboard := make([][]tba.ReplyButton, 0, len(options))
+	for _, o := range options {
+		keyboard = append(keyboard, []tba.ReplyButton{{Text: o}})
+	}
+
+	sendOpt := formatSendOpt(format)
+	sendOpt.ReplyMarkup = &tba.ReplyMarkup{
+		ReplyKeyboard:       keyboard,
+		ResizeReplyKeyboard: true,
+		OneTimeKeyboard:     true,
+	}
+
+	_, err := t.api.Send(telegramChat(chat), text, sendOpt)
+	return classifySendError(err)
+}
+
+// SendDocument sends data as a file with given name.
+func (t *telegramMessenger) SendDocument(chat chatid, fileName string, data []byte, caption string) error {
+	doc := &tba.Document{
+		File:     tba.FromReader(bytes.NewReader(data)),
+		FileName: fileName,
+		Caption:  caption,
+	}
+
+	_, err := t.api.Send(telegramChat(chat), doc, defaultSendOpt())
+	return classifySendError(err)
+}
+
+// ChatAdmins asks telegram for administrators of group.
+func (t *telegramMessenger) ChatAdmins(chat chatid) ([]int64, error) {
+	members, err := t.api.AdminsOf(telegramChat(chat))
+	if err != nil {
+		return nil, err
+	}
+
+	admins := make([]int64, 0, len(members))
+	for _, m := range members {
+		if m.User != nil {
+			admins = append(admins, int64(m.User.ID))
+		}
+	}
+	return admins, nil
+}
+
+// SetCommands sets list of commands shown by telegram clients.
+func (t *telegramMessenger) SetCommands(commands []commandInfo) error {
+	cmds := make([]tba.Command, 0, len(commands))
+	for _, c := range commands {
+		cmds = append(cmds, tba.Command{Text: c.Name, Description: c.Description})
+	}
+	return t.api.SetCommands(cmds)
+}
+
+// Start passes every text message to handler and starts polling telegram for updates.
+func (t *telegramMessenger) Start(handler func(Update)) {
+	t.api.Handle(tba.OnText, func(m *tba.Message) {
+		u := Update{ChatID: chatid(m.Chat.ID), Text: m.Text}
+		if m.Sender != nil {
+			u.UserID = int64(m.Sender.ID)
+		}
+		handler(u)
+	})
+
+	t.setRunning(true)
+	t.api.Start()
+}
+
+// Err returns error because of which poller stopped receiving updates, e.g. webhook could not be set. Long polling stops only when Stop is called, so it has no error.
+func (t *telegramMessenger) Err() error {
+	if p, ok := t.poller.(interface{ Err() error }); ok {
+		return p.Err()
+	}
+	return nil
+}
+
+// Stop stops polling telegram for updates.
+func (t *telegramMessenger) Stop() {
+	t.setRunning(false)
+	t.api.Stop()
+}
+
+func (t *telegramMessenger) setRunning(running bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running = running
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// validateData checks data of configured backend without changing it and prints every problem it finds. It returns error if there are any problems, so it can be used in scripts.
+func validateData(sc storageConfig) error {
+	var s Store
+	var problems []string
+	var err error
+	if sc.Backend == "sqlite" {
+		s, problems, err = checkSQLFile(sc.SQLitePath, sc.keys)
+	} else {
+		s, problems, err = checkJSONFiles(sc)
+	}
+	if err != nil {
+		return err
+	}
+
+	if s != nil {
+		defer s.Close()
+		found, err := checkStore(s)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, found...)
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return errors.New("found " + strconv.Itoa(len(problems)) + " problems")
+	}
+	fmt.Println("Data is valid")
+	return nil
+}
+
+// checkJSONFiles reads data files and their backups like bot does on start, but doesn't migrate or restore them. It returns store with data of files, so it can be checked further.
+func checkJSONFiles(sc storageConfig) (*jsonStore, []string, error) {
+	js := newMemoryJSONStore()
+	files := []struct {
+		name  string
+		kind  string
+		data  interface{}
+		empty func() interface{}
+	}{
+		{sc.FlashcardsFile, flashcardsKind, &js.flashcards, func() interface{} { return &flashcardsData{} }},
+		{sc.RemindersFile, remindersKind, &js.reminders, func() interface{} { return &remindersData{} }},
+		{sc.SchedulesFile, schedulesKind, &js.schedules, func() interface{} { return &schedulesData{} }},
+		{sc.OutboxFile, outboxKind, &js.outbox, func() interface{} { return &[]pendingMessage{} }},
+		{sc.LanguagesFile, languagesKind, &js.languages, func() interface{} { return &languagesData{} }},
+	}
+
+	problems := []string{}
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f.name)
+		if os.IsNotExist(err) {
+			log.WithField("file", f.name).Info("File doesn't exist, bot will create it")
+		} else if err != nil {
+			problems = append(problems, f.name+": "+err.Error())
+		} else if migrated, err := decodeJSONFile(content, f.kind, sc.keys, f.data); err != nil {
+			problems = append(problems, f.name+": "+err.Error())
+		} else if migrated {
+			log.WithField("file", f.name).Info("File is in older format, it will be migrated on start or with migrate command")
+		}
+
+		// broken backup doesn't stop bot, but it can't replace file when it breaks
+		for n := 1; n <= sc.Backups; n++ {
+			backup := backupFileName(f.name, n)
+			content, err := ioutil.ReadFile(backup)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err == nil {
+				_, err = decodeJSONFile(content, f.kind, sc.keys, f.empty())
+			}
+			if err != nil {
+				problems = append(problems, backup+": "+err.Error())
+			}
+		}
+	}
+
+	js.ensureMaps()
+	return js, problems, nil
+}
+
+// checkSQLFile opens database read only and checks its schema version, integrity and foreign keys. Data is returned for further checks only if schema is current, because older schema can't be queried by bot.
+func checkSQLFile(path string, keys *keyring) (*sqlStore, []string, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		log.WithField("db", path).Info("Database doesn't exist, bot will create it")
+		return nil, nil, nil
+	}
+
+	db, err := openSQLite("file:" + path + "?mode=ro")
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &sqlStore{db: db, path: path, keys: keys}
+
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		db.Close()
+		return nil, nil, errors.New("could not read schema version: " + err.Error())
+	}
+	if version > len(sqlMigrations) {
+		db.Close()
+		return nil, []string{"database schema is newer than bot, update the bot"}, nil
+	}
+	if version < len(sqlMigrations) {
+		log.WithFields(log.Fields{
+			"db":      path,
+			"version": version,
+		}).Warn("Database schema is older than bot, run migrate to check its data")
+		db.Close()
+		return nil, nil, nil
+	}
+
+	problems := []string{}
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err == nil && result != "ok" {
+			problems = append(problems, "integrity: "+result)
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var table, parent string
+		var rowID, fk sql.NullInt64
+		if err := rows.Scan(&table, &rowID, &parent, &fk); err == nil {
+			problems = append(problems, "row "+strconv.FormatInt(rowID.Int64, 10)+" of "+table+" references missing row of "+parent)
+		}
+	}
+	rows.Close()
+
+	return s, problems, nil
+}
+
+// checkStore looks for data which bot never writes, e.g. flashcards without term, reminders without date or classes on unknown weekday. It returns description of every problem.
+func checkStore(s Store) ([]string, error) {
+	chats, err := s.Chats()
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+	for _, chatID := range chats {
+		found := []string{}
+
+		topics, err := s.Flashcards(chatID)
+		if err != nil {
+			return nil, err
+		}
+		for top, fc := range topics {
+			if strings.TrimSpace(string(top)) == "" {
+				found = append(found, "topic without name")
+			}
+			if len(fc) == 0 {
+				found = append(found, "topic "+strconv.Quote(string(top))+" has no flashcards")
+			}
+			for term, definition := range fc {
+				if strings.TrimSpace(term) == "" {
+					found = append(found, "flashcard without term in topic "+strconv.Quote(string(top)))
+				}
+				if strings.TrimSpace(definition) == "" {
+					found = append(found, "flashcard "+strconv.Quote(term)+" in topic "+strconv.Quote(string(top))+" has no definition")
+				}
+			}
+		}
+
+		reminders, err := s.Reminders(chatID)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[reminderKey]bool)
+		for _, r := range reminders {
+			if r.Date.IsZero() {
+				found = append(found, "reminder "+strconv.Quote(r.Title)+" has no date")
+			}
+			if strings.TrimSpace(r.Title) == "" {
+				found = append(found, "reminder on "+r.Date.Format(time.RFC3339)+" has no title")
+			}
+			key := reminderKey{chatID: chatID, date: r.Date.Unix(), title: r.Title}
+			if seen[key] {
+				found = append(found, "reminder "+strconv.Quote(r.Title)+" on "+r.Date.Format(time.RFC3339)+" is saved twice")
+			}
+			seen[key] = true
+		}
+
+		sd, err := s.Schedule(chatID)
+		if err != nil {
+			return nil, err
+		}
+		for wd, day := range sd {
+			if wd < monday || wd > sunday {
+				found = append(found, "classes on unknown weekday "+strconv.Itoa(int(wd)))
+				continue
+			}
+			for _, c := range day {
+				if strings.TrimSpace(c.Name) == "" {
+					found = append(found, "class without name on "+wd.Name(english))
+				}
+				if c.Ends.Before(c.Starts) {
+					found = append(found, "class "+strconv.Quote(c.Name)+" on "+wd.Name(english)+" ends before it starts")
+				}
+			}
+		}
+
+		lang, err := s.Language(chatID)
+		if err != nil {
+			return nil, err
+		}
+		known := lang == ""
+		for _, l := range languages() {
+			known = known || lang == l
+		}
+		if !known {
+			found = append(found, "unknown language "+strconv.Quote(string(lang)))
+		}
+
+		// maps have random order, sorted problems are easier to compare between runs
+		sort.Strings(found)
+		for _, p := range found {
+			problems = append(problems, "chat "+strconv.FormatInt(int64(chatID), 10)+": "+p)
+		}
+	}
+
+	pending, err := s.PendingMessages()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, m := range pending {
+		switch {
+		case m.ID == "":
+			problems = append(problems, "pending message without ID")
+		case ids[m.ID]:
+			problems = append(problems, "pending message "+m.ID+" is saved twice")
+		case m.ChatID == 0:
+			problems = append(problems, "pending message "+m.ID+" has no chat")
+		case strings.TrimSpace(m.Text) == "":
+			problems = append(problems, "pending message "+m.ID+" has no text")
+		}
+		ids[m.ID] = true
+	}
+	return problems, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tba "gopkg.in/tucnak/telebot.v2" //telegram bot api
+)
+
+const (
+	pollingMode = "polling"
+	webhookMode = "webhook"
+)
+
+// secretTokenHeader is a header in which telegram sends secret token given in setWebhook.
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// secretTokenRegexp matches secret tokens accepted by telegram.
+var secretTokenRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhookConfig stores settings of built-in webhook server.
+// Listen is address for http server, e.g. ":8443".
+// Path is an endpoint on which updates are received.
+// URL is public address registered in telegram, it may differ from Listen when bot runs behind reverse proxy.
+// Secret is compared with secret token header of every request.
+// CertFile and KeyFile enable TLS, without them server uses plain HTTP and expects proxy to terminate TLS.
+type webhookConfig struct {
+	Listen   string `yaml:"listen"`
+	Path     string `yaml:"path"`
+	URL      string `yaml:"url"`
+	Secret   string `yaml:"secret"`
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// webhookPoller is a telebot poller which receives updates from telegram through built-in http server instead of long polling.
+// ServerErr is set when webhook can't be set or http server stops because of error, then bot is stopped.
+type webhookPoller struct {
+	config webhookConfig
+	dest   chan tba.Update
+
+	mu        sync.Mutex
+	serverErr error
+}
+
+// telegramPoller creates poller chosen by telegram mode. Config has to be validated before.
+func telegramPoller(tc telegramConfig) tba.Poller {
+	if tc.Mode == webhookMode {
+		return &webhookPoller{config: tc.Webhook}
+	}
+	return &tba.LongPoller{Timeout: 10 * time.Second}
+}
+
+// validate checks if webhook config is complete and sets defaults.
+func (wc *webhookConfig) validate() error {
+	if wc.URL == "" {
+		return errors.New("webhook url is required in webhook mode")
+	}
+	if !strings.HasPrefix(wc.URL, "https://") {
+		return errors.New("webhook url must use https")
+	}
+	if wc.Secret == "" {
+		return errors.New("webhook secret is required in webhook mode")
+	}
+	if !secretTokenRegexp.MatchString(wc.Secret) {
+		return errors.New("webhook secret can contain only letters, digits, _ and - and be at most 256 characters long")
+	}
+	if (wc.CertFile == "") != (wc.KeyFile == "") {
+		return errors.New("webhook tls needs both certificate and key file")
+	}
+	if wc.Listen == "" {
+		wc.Listen = ":8443"
+	}
+	if wc.Path == "" {
+		wc.Path = "/"
+	}
+	if !strings.HasPrefix(wc.Path, "/") {
+		wc.Path = "/" + wc.Path
+	}
+	return nil
+}
+
+// setWebhook registers webhook url and secret token in telegram. Raw call is used, because telebot doesn't support secret token.
+func (w *webhookPoller) setWebhook(b *tba.Bot) error {
+	params := map[string]string{
+		"url":          w.config.URL,
+		"secret_token": w.config.Secret,
+	}
+
+	_, err := b.Raw("setWebhook", params)
+	return err
+}
+
+// Poll registers webhook and starts http server. It blocks until stop is closed, then it shuts server down.
+func (w *webhookPoller) Poll(b *tba.Bot, dest chan tba.Update, stop chan struct{}) {
+	webhookLogger := log.WithFields(log.Fields{
+		"listen": w.config.Listen,
+		"path":   w.config.Path,
+	})
+
+	if err := w.setWebhook(b); err != nil {
+		webhookLogger.Error("Could not set webhook: " + err.Error())
+		w.mu.Lock()
+		w.serverErr = err
+		w.mu.Unlock()
+		// bot can't receive updates, so it stops like after signal, which sends waiting messages and closes store
+		go b.Stop()
+		return
+	}
+	w.dest = dest
+
+	mux := http.NewServeMux()
+	mux.Handle(w.config.Path, w)
+	server := &http.Server{Addr: w.config.Listen, Handler: mux}
+
+	go func() {
+		var err error
+		if w.config.CertFile != "" {
+			err = server.ListenAndServeTLS(w.config.CertFile, w.config.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			webhookLogger.Error("Webhook server failed: " + err.Error())
+			w.mu.Lock()
+			w.serverErr = err
+			w.mu.Unlock()
+			go b.Stop()
+		}
+	}()
+	webhookLogger.Info("Webhook server started")
+
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		webhookLogger.Error("Could not shut webhook server down: " + err.Error())
+	}
+}
+
+// Health reports error if webhook server failed.
+func (w *webhookPoller) Health() error {
+	if err := w.Err(); err != nil {
+		return errors.New("webhook server failed: " + err.Error())
+	}
+	return nil
+}
+
+// Err returns error because of which poller stopped the bot, or nil if it was stopped normally.
+func (w *webhookPoller) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.serverErr
+}
+
+// ServeHTTP verifies secret token and passes decoded update to telebot.
+func (w *webhookPoller) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(w.config.Secret)) != 1 {
+		log.WithFields(log.Fields{
+			"remote": r.RemoteAddr,
+		}).Warn("Webhook request with wrong secret token")
+		http.Error(rw, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var update tba.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(rw, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case w.dest <- update:
+		rw.WriteHeader(http.StatusOK)
+	case <-r.Context().Done():
+	}
+}